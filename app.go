@@ -20,9 +20,13 @@ type app struct {
 	watchdog *watchdog
 }
 
-// newApp creates a new application struct.
-func newApp(port int, requests []*dnsStream) *app {
-	w := newWatchdog(requests)
+// newApp creates a new application struct. The metrics are registered
+// with the custom labels of the requests, and the watchdog workers start
+// their checks after the given offset.
+func newApp(port int, startOffset time.Duration, requests []*dnsStream) *app {
+	newMetrics(requestLabelNames(requests))
+	registerMetrics()
+	w := newWatchdog(requests, startOffset)
 	return &app{
 		port:     port,
 		watchdog: w,
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// checkOutputs are the formats the results of the check command can be
+// written in.
+var checkOutputs = []string{"text", "json", "junit", "tap"}
+
+// checkStatus is the outcome of running a request once.
+type checkStatus string
+
+const (
+	// checkPassed means the response was verified successfully.
+	checkPassed checkStatus = "ok"
+	// checkFailed means the response didn't pass verification.
+	checkFailed checkStatus = "failed"
+	// checkErrored means the request is invalid or got no response.
+	checkErrored checkStatus = "error"
+)
+
+// checkResult is the outcome of running a request of the config once.
+type checkResult struct {
+	Domain       string      `json:"domain"`
+	QueryType    string      `json:"queryType"`
+	Resolver     string      `json:"resolver"`
+	Transport    string      `json:"transport"`
+	Status       checkStatus `json:"status"`
+	ResponseCode string      `json:"responseCode,omitempty"`
+	Answers      []string    `json:"answers,omitempty"`
+	RTTSeconds   float64     `json:"rttSeconds"`
+	// Message is why the request failed or errored.
+	Message string `json:"message,omitempty"`
+	// index is the position of the request in the requests of the config.
+	index int
+}
+
+// name identifies the request of the result in the reports.
+func (r *checkResult) name() string {
+	// Invalid requests have no resolver or transport
+	if r.Resolver == "" {
+		return fmt.Sprintf("%s %s", r.Domain, r.QueryType)
+	}
+	return fmt.Sprintf("%s %s via %s (%s)", r.Domain, r.QueryType, r.Resolver, r.Transport)
+}
+
+// runCheck runs every request of the config once, concurrently, and reports
+// their results. It exits with exitError when any request is invalid or got
+// no response, with exitFailure when any response failed verification and
+// with exitOK otherwise.
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	flags := pflag.NewFlagSet("check", pflag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.StringP("config", "c", "", "path of the config file, by default config.yaml in the current directory or /etc/dns-verifier")
+	output := flags.StringP("output", "o", "text", "format of the results, one of "+strings.Join(checkOutputs, ", "))
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if !slices.Contains(checkOutputs, *output) {
+		fmt.Fprintf(stderr, "error:%s is not a supported output, use one of %s\n", *output, strings.Join(checkOutputs, ", "))
+		return exitUsage
+	}
+
+	initCommandLogging(stderr)
+	r, err := loadYamlConfig(*path)
+	if err != nil {
+		fmt.Fprintf(stderr, "error:%v\n", err)
+		return exitError
+	}
+
+	results := checkRequests(r, newResolverClient)
+	if err := writeCheckResults(stdout, *output, results); err != nil {
+		fmt.Fprintf(stderr, "error:%v\n", err)
+		return exitError
+	}
+	return checkExitCode(results)
+}
+
+// checkRequests runs every request of the config once and returns their
+// results in the order of the config, with the invalid requests among the
+// valid ones.
+func checkRequests(r *YamlRequests, newClient func(r *dnsRequest) resolverClient) []*checkResult {
+	streams, requestErrors := r.cleanRequests()
+	results := make([]*checkResult, 0, len(requestErrors)+len(streams))
+	for _, e := range requestErrors {
+		results = append(results, &checkResult{
+			Domain:    e.domain,
+			QueryType: r.Requests[e.index].QueryType,
+			Status:    checkErrored,
+			Message:   e.Error(),
+			index:     e.index,
+		})
+	}
+	results = append(results, runChecks(streams, newClient)...)
+	// The streams of a request with several resolvers keep their order
+	slices.SortStableFunc(results, func(a, b *checkResult) int {
+		return a.index - b.index
+	})
+	return results
+}
+
+// runChecks queries every stream once, concurrently, and returns their
+// results in the order of the streams.
+func runChecks(streams []*dnsStream, newClient func(r *dnsRequest) resolverClient) []*checkResult {
+	results := make([]*checkResult, len(streams))
+	var wg sync.WaitGroup
+	for i, s := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := newClient(&s.request)
+			defer client.close()
+			results[i] = newCheckResult(s, s.query(client))
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// newCheckResult returns the result of a stream after it was queried.
+func newCheckResult(s *dnsStream, err error) *checkResult {
+	r := &checkResult{
+		Domain:    s.request.domain,
+		QueryType: s.request.queryType,
+		Resolver:  s.resolverLabel(),
+		Transport: s.transportLabel(),
+		index:     s.request.index,
+	}
+	switch {
+	case err != nil:
+		r.Status = checkErrored
+		r.Message = err.Error()
+		return r
+	case s.verificationStatus == 1:
+		r.Status = checkPassed
+	default:
+		r.Status = checkFailed
+		r.Message = s.failure()
+	}
+	r.ResponseCode = s.response.code.String()
+	r.Answers = s.response.answers
+	r.RTTSeconds = s.rtt.Seconds()
+	return r
+}
+
+// checkExitCode returns the exit code of the check command for the results,
+// with errors taking precedence over failures.
+func checkExitCode(results []*checkResult) int {
+	code := exitOK
+	for _, r := range results {
+		switch r.Status {
+		case checkErrored:
+			return exitError
+		case checkFailed:
+			code = exitFailure
+		case checkPassed:
+		}
+	}
+	return code
+}
+
+// writeCheckResults writes the results in the given output format.
+func writeCheckResults(w io.Writer, output string, results []*checkResult) error {
+	var err error
+	switch output {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	case "junit":
+		err = writeJUnit(w, results)
+	case "tap":
+		err = writeTAP(w, results)
+	default:
+		err = writeCheckTable(w, results)
+	}
+	return errors.Wrap(err, "Cannot write the check results")
+}
+
+// writeCheckTable writes the results as a table, one row per request.
+func writeCheckTable(w io.Writer, results []*checkResult) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "STATUS\tDOMAIN\tQTYPE\tRESOLVER\tTRANSPORT\tRCODE\tRTT\tANSWERS\tMESSAGE")
+	for _, r := range results {
+		rtt := time.Duration(r.RTTSeconds * float64(time.Second)).Round(time.Microsecond)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.Status, r.Domain, r.QueryType, r.Resolver, r.Transport, r.ResponseCode, rtt, strings.Join(r.Answers, ","), r.Message)
+	}
+	return tw.Flush()
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Time     float64         `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+}
+
+// writeJUnit writes the results as a JUnit XML report, one test case per
+// request.
+func writeJUnit(w io.Writer, results []*checkResult) error {
+	suite := junitTestSuite{Name: "dns-verifier", Tests: len(results)}
+	for _, r := range results {
+		tc := junitTestCase{Name: r.name(), ClassName: "dns-verifier." + r.Domain, Time: r.RTTSeconds}
+		switch r.Status {
+		case checkFailed:
+			suite.Failures++
+			tc.Failure = &junitProblem{Message: r.Message, Type: string(r.Status)}
+		case checkErrored:
+			suite.Errors++
+			tc.Error = &junitProblem{Message: r.Message, Type: string(r.Status)}
+		case checkPassed:
+		}
+		suite.Time += r.RTTSeconds
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeTAP writes the results in the Test Anything Protocol, one test per
+// request, with the details of failed tests in a YAML block.
+func writeTAP(w io.Writer, results []*checkResult) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "TAP version 13\n1..%d\n", len(results))
+	for i, r := range results {
+		if r.Status == checkPassed {
+			fmt.Fprintf(&b, "ok %d - %s\n", i+1, r.name())
+			continue
+		}
+		fmt.Fprintf(&b, "not ok %d - %s\n  ---\n  status: %s\n  message: %q\n", i+1, r.name(), r.Status, r.Message)
+		if r.ResponseCode != "" {
+			fmt.Fprintf(&b, "  responseCode: %s\n", r.ResponseCode)
+		}
+		b.WriteString("  ...\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkClientTest answers with the A records configured for each resolver
+// address, failing for the resolvers without any.
+type checkClientTest struct {
+	consensusClientTest
+}
+
+func (c *checkClientTest) close() {}
+
+func newTestCheckStream(resolver string, expected ...string) *dnsStream {
+	return newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A", resolver: &resolver, expectedResponse: expected}, 30)
+}
+
+func TestRunChecks(t *testing.T) {
+	t.Parallel()
+	client := &checkClientTest{consensusClientTest{answers: map[string][]string{
+		"10.0.0.1:53": {"1.1.1.1"},
+		"10.0.0.2:53": {"2.2.2.2"},
+	}}}
+	streams := []*dnsStream{
+		newTestCheckStream("10.0.0.1", "1.1.1.1"),
+		newTestCheckStream("10.0.0.2", "1.1.1.1"),
+		newTestCheckStream("10.0.0.3"),
+	}
+	results := runChecks(streams, func(*dnsRequest) resolverClient { return client })
+	require.Len(t, results, 3)
+
+	assert.Equal(t, checkPassed, results[0].Status)
+	assert.Equal(t, "10.0.0.1", results[0].Resolver)
+	assert.Equal(t, "udp", results[0].Transport)
+	assert.Equal(t, "NOERROR", results[0].ResponseCode)
+	assert.Equal(t, []string{"1.1.1.1"}, results[0].Answers)
+	assert.Empty(t, results[0].Message)
+
+	assert.Equal(t, checkFailed, results[1].Status)
+	assert.Equal(t, []string{"2.2.2.2"}, results[1].Answers)
+	assert.Contains(t, results[1].Message, "do not match")
+
+	assert.Equal(t, checkErrored, results[2].Status)
+	assert.Empty(t, results[2].ResponseCode)
+	assert.Contains(t, results[2].Message, "no answer from 10.0.0.3:53")
+}
+
+func TestCheckRequestsConfigOrder(t *testing.T) {
+	t.Parallel()
+	client := &checkClientTest{consensusClientTest{answers: map[string][]string{
+		"10.0.0.1:53": {"1.1.1.1"},
+		"10.0.0.2:53": {"2.2.2.2"},
+	}}}
+	first, second := "10.0.0.1", "10.0.0.2"
+	r := &YamlRequests{Requests: []YamlRequest{
+		{Domain: "thebeat.co", Resolver: &second},
+		{Domain: "thebeat.co", QueryType: "FOO"},
+		{Domain: "thebeat.co", Resolvers: []string{first, second}},
+		{Domain: ""},
+	}}
+	results := checkRequests(r, func(*dnsRequest) resolverClient { return client })
+	require.Len(t, results, 5)
+
+	// Invalid requests are reported in their place in the config
+	expected := []struct {
+		status   checkStatus
+		resolver string
+	}{
+		{checkPassed, second},
+		{checkErrored, ""},
+		{checkPassed, first},
+		{checkPassed, second},
+		{checkErrored, ""},
+	}
+	for i, e := range expected {
+		assert.Equal(t, e.status, results[i].Status, "result %d", i)
+		assert.Equal(t, e.resolver, results[i].Resolver, "result %d", i)
+	}
+	assert.Contains(t, results[1].Message, "requests[1]")
+	assert.Contains(t, results[4].Message, "requests[3]")
+}
+
+func TestCheckExitCode(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		statuses []checkStatus
+		expected int
+	}{
+		"No results":             {nil, exitOK},
+		"All passed":             {[]checkStatus{checkPassed, checkPassed}, exitOK},
+		"Failed":                 {[]checkStatus{checkPassed, checkFailed}, exitFailure},
+		"Errored":                {[]checkStatus{checkErrored, checkPassed}, exitError},
+		"Errors over failures":   {[]checkStatus{checkFailed, checkErrored}, exitError},
+		"Failures before errors": {[]checkStatus{checkErrored, checkFailed}, exitError},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			results := make([]*checkResult, 0, len(tt.statuses))
+			for _, s := range tt.statuses {
+				results = append(results, &checkResult{Status: s})
+			}
+			assert.Equal(t, tt.expected, checkExitCode(results))
+		})
+	}
+}
+
+func testCheckResults() []*checkResult {
+	return []*checkResult{
+		{Domain: "thebeat.co", QueryType: "A", Resolver: "10.0.0.1", Transport: "udp", Status: checkPassed, ResponseCode: "NOERROR", Answers: []string{"1.1.1.1"}, RTTSeconds: 0.012},
+		{Domain: "thebeat.co", QueryType: "NS", Resolver: "10.0.0.1", Transport: "tcp", Status: checkFailed, ResponseCode: "NXDOMAIN", RTTSeconds: 0.02, Message: "unexpected response code"},
+		{Domain: "google.com", QueryType: "FOO", Status: checkErrored, Message: "FOO is not a supported DNS query type"},
+	}
+}
+
+func TestWriteCheckResultsText(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	require.NoError(t, writeCheckResults(&out, "text", testCheckResults()))
+	expected := "" +
+		"STATUS  DOMAIN      QTYPE  RESOLVER  TRANSPORT  RCODE     RTT   ANSWERS  MESSAGE\n" +
+		"ok      thebeat.co  A      10.0.0.1  udp        NOERROR   12ms  1.1.1.1  \n" +
+		"failed  thebeat.co  NS     10.0.0.1  tcp        NXDOMAIN  20ms           unexpected response code\n" +
+		"error   google.com  FOO                                   0s             FOO is not a supported DNS query type\n"
+	assert.Equal(t, expected, out.String())
+}
+
+func TestWriteCheckResultsJSON(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	require.NoError(t, writeCheckResults(&out, "json", testCheckResults()))
+	var results []*checkResult
+	require.NoError(t, json.Unmarshal(out.Bytes(), &results))
+	assert.Equal(t, testCheckResults(), results)
+}
+
+func TestWriteCheckResultsJUnit(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	require.NoError(t, writeCheckResults(&out, "junit", testCheckResults()))
+
+	var report junitTestSuites
+	require.NoError(t, xml.Unmarshal(out.Bytes(), &report))
+	require.Len(t, report.Suites, 1)
+	suite := report.Suites[0]
+	assert.Equal(t, 3, suite.Tests)
+	assert.Equal(t, 1, suite.Failures)
+	assert.Equal(t, 1, suite.Errors)
+	require.Len(t, suite.Cases, 3)
+	assert.Equal(t, "thebeat.co A via 10.0.0.1 (udp)", suite.Cases[0].Name)
+	assert.Nil(t, suite.Cases[0].Failure)
+	assert.Nil(t, suite.Cases[0].Error)
+	require.NotNil(t, suite.Cases[1].Failure)
+	assert.Equal(t, "unexpected response code", suite.Cases[1].Failure.Message)
+	assert.Equal(t, "google.com FOO", suite.Cases[2].Name)
+	require.NotNil(t, suite.Cases[2].Error)
+}
+
+func TestWriteCheckResultsTAP(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	require.NoError(t, writeCheckResults(&out, "tap", testCheckResults()))
+	expected := `TAP version 13
+1..3
+ok 1 - thebeat.co A via 10.0.0.1 (udp)
+not ok 2 - thebeat.co NS via 10.0.0.1 (tcp)
+  ---
+  status: failed
+  message: "unexpected response code"
+  responseCode: NXDOMAIN
+  ...
+not ok 3 - google.com FOO
+  ---
+  status: error
+  message: "FOO is not a supported DNS query type"
+  ...
+`
+	assert.Equal(t, expected, out.String())
+}
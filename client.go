@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// transport is the protocol a request uses to talk to its resolver.
+type transport string
+
+const (
+	transportUDP   transport = "udp"
+	transportTCP   transport = "tcp"
+	transportTLS   transport = "tls"
+	transportHTTPS transport = "https"
+	// transportSystem looks domains up through the resolver of the system
+	// instead of sending DNS queries.
+	transportSystem transport = "system"
+)
+
+// newTransport returns the transport of the given name, falling back
+// to UDP when the name is empty.
+func newTransport(name string) (transport, error) {
+	t := transport(strings.ToLower(name))
+	switch t {
+	case "":
+		return transportUDP, nil
+	case transportUDP, transportTCP, transportTLS, transportHTTPS, transportSystem:
+		return t, nil
+	}
+	return "", errors.Errorf("%s is not a supported transport", name)
+}
+
+// defaultPort returns the port resolvers listen to for the transport.
+func (t transport) defaultPort() string {
+	switch t {
+	case transportTLS:
+		return "853"
+	case transportHTTPS:
+		return "443"
+	case transportUDP, transportTCP, transportSystem:
+	}
+	return "53"
+}
+
+// newTLSConfig creates the TLS configuration used to talk to DNS-over-TLS
+// resolvers. When serverName is empty, the host of the resolver is used
+// instead. When caFile is set, the resolver certificate is verified against
+// the CAs in that bundle instead of the system ones.
+func newTLSConfig(serverName, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return cfg, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Cannot read CA bundle %s", caFile)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("No valid certificates found in CA bundle %s", caFile)
+	}
+	cfg.RootCAs = pool
+
+	return cfg, nil
+}
+
+type dnsClientInterface interface {
+	query(*dns.Msg, string) (*dns.Msg, time.Duration, error)
+}
+
+// exchangeStats holds information about the last exchange of a client
+// besides its response and RTT.
+type exchangeStats struct {
+	// handshake is the duration of the TLS handshake, if a new connection
+	// had to be established for the exchange.
+	handshake time.Duration
+	// httpStatus is the HTTP status code returned by a DoH resolver, or
+	// httpStatusTransportError if no HTTP response was received.
+	httpStatus string
+	// truncated is set when the UDP response was truncated and the query
+	// was repeated over TCP.
+	truncated bool
+}
+
+// exchangeReporter is implemented by clients that can report more details
+// about their last exchange.
+type exchangeReporter interface {
+	lastExchange() exchangeStats
+}
+
+// timeoutSetter is implemented by clients whose timeout can be changed,
+// e.g. to honour the timeout option of the resolv.conf.
+type timeoutSetter interface {
+	setTimeout(timeout time.Duration)
+}
+
+// resolverClient is a dnsClientInterface that holds resources, like open
+// connections, which have to be released when it is no longer used.
+type resolverClient interface {
+	dnsClientInterface
+	close()
+}
+
+// newResolverClient returns the client for the transport of the request.
+func newResolverClient(r *dnsRequest) resolverClient {
+	switch r.transport {
+	case transportHTTPS:
+		return newDoHClient(r)
+	case transportSystem:
+		return newSystemClient(r)
+	case transportUDP, transportTCP, transportTLS:
+	}
+	return newDNSClient(r)
+}
+
+type dnsClient struct {
+	client    *dns.Client
+	tcpClient *dns.Client
+	transport transport
+	tlsConfig *tls.Config
+	// resolverAddrs are the addresses of the resolvers of the request,
+	// used to find the hostname of the resolver a connection is made to.
+	resolverAddrs map[string]*resolverAddr
+	// timeout bounds establishing TLS connections.
+	timeout  time.Duration
+	conn     *dns.Conn
+	connAddr string
+	stats    exchangeStats
+}
+
+func newDNSClient(r *dnsRequest) *dnsClient {
+	c := &dns.Client{Net: "udp", ReadTimeout: DefaultTimeout, Timeout: r.timeout}
+	tcp := &dns.Client{Net: "tcp", ReadTimeout: DefaultTimeout, Timeout: r.timeout}
+	t := r.transport
+	if t == "" {
+		t = transportUDP
+	}
+	tlsConfig := r.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return &dnsClient{client: c, tcpClient: tcp, transport: t, tlsConfig: tlsConfig, resolverAddrs: r.resolverAddrs, timeout: r.queryTimeout()}
+}
+
+func (d *dnsClient) query(query *dns.Msg, resolver string) (*dns.Msg, time.Duration, error) {
+	d.stats = exchangeStats{}
+	if d.transport == transportTLS {
+		return d.exchangeWithConn(query, resolver)
+	}
+	if d.transport == transportTCP {
+		return d.tcpClient.Exchange(query, resolver)
+	}
+
+	response, rtt, err := d.client.Exchange(query, resolver)
+	if err != nil || !response.Truncated {
+		return response, rtt, err
+	}
+
+	// The answer didn't fit in a UDP packet, so verifying the partial
+	// answer would give false failures. Repeat the query over TCP instead.
+	d.stats.truncated = true
+	return d.tcpClient.Exchange(query, resolver)
+}
+
+func (d *dnsClient) lastExchange() exchangeStats {
+	return d.stats
+}
+
+// exchangeWithConn sends the query over a connection that is kept open
+// between queries, so the cost of the TLS handshake is only paid when the
+// connection has to be established. Resolvers close idle connections, so
+// if the exchange fails on a reused connection it is retried once on a new one.
+func (d *dnsClient) exchangeWithConn(query *dns.Msg, resolver string) (*dns.Msg, time.Duration, error) {
+	reused := d.conn != nil && d.connAddr == resolver
+	if !reused {
+		if err := d.dial(resolver); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	response, rtt, err := d.client.ExchangeWithConn(query, d.conn)
+	if err != nil {
+		d.close()
+		if !reused {
+			return nil, 0, err
+		}
+		if err := d.dial(resolver); err != nil {
+			return nil, 0, err
+		}
+		response, rtt, err = d.client.ExchangeWithConn(query, d.conn)
+		if err != nil {
+			d.close()
+			return nil, 0, err
+		}
+	}
+
+	return response, rtt, nil
+}
+
+// dial opens a new TLS connection to the resolver and records how long
+// the TLS handshake took.
+func (d *dnsClient) dial(resolver string) error {
+	d.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", resolver)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot connect to %s", resolver)
+	}
+
+	tlsConfig := d.tlsConfig
+	if tlsConfig.ServerName == "" {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = d.serverName(resolver)
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	start := time.Now()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return errors.Wrapf(err, "TLS handshake with %s failed", resolver)
+	}
+	d.stats.handshake = time.Since(start)
+
+	d.conn = &dns.Conn{Conn: tlsConn}
+	d.connAddr = resolver
+	return nil
+}
+
+// setTimeout sets how long the client waits for a response, including
+// establishing the connection over TCP.
+func (d *dnsClient) setTimeout(timeout time.Duration) {
+	d.client.Timeout = timeout
+	d.tcpClient.Timeout = timeout
+	d.timeout = timeout
+}
+
+// serverName returns the name the certificate of the resolver is verified
+// against, which is its hostname for resolvers configured by hostname and
+// its IP address otherwise.
+func (d *dnsClient) serverName(resolver string) string {
+	for _, addr := range d.resolverAddrs {
+		if addr.isHostname() && addr.String() == resolver {
+			return addr.host
+		}
+	}
+	host, _, _ := net.SplitHostPort(resolver)
+	return host
+}
+
+// close closes the connection kept open between queries, if any.
+func (d *dnsClient) close() {
+	if d.conn == nil {
+		return
+	}
+	d.conn.Close()
+	d.conn = nil
+	d.connAddr = ""
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCertificate creates a self-signed certificate for the given server
+// name and writes it as a CA bundle in a temporary directory.
+func newTestCertificate(t *testing.T, serverName string) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: serverName},
+		DNSNames:              []string{serverName},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+// testHandler answers every A question with 127.0.0.1.
+func testHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("127.0.0.1"),
+	})
+	_ = w.WriteMsg(m)
+}
+
+// startTLSServer starts a local DNS-over-TLS server and returns its address.
+func startTLSServer(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	server := &dns.Server{Listener: l, Net: "tcp-tls", Handler: dns.HandlerFunc(testHandler), NotifyStartedFunc: func() { close(started) }}
+	go func() {
+		_ = server.ActivateAndServe()
+	}()
+	<-started
+	t.Cleanup(func() {
+		_ = server.Shutdown()
+	})
+
+	return l.Addr().String()
+}
+
+func TestNewTransport(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		input    string
+		expected transport
+		wantErr  bool
+	}{
+		"Default":   {"", transportUDP, false},
+		"UDP":       {"udp", transportUDP, false},
+		"TCP":       {"Tcp", transportTCP, false},
+		"TLS":       {"TLS", transportTLS, false},
+		"HTTPS":     {"https", transportHTTPS, false},
+		"Unknown":   {"quic", "", true},
+		"Unrelated": {"http", "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			tr, err := newTransport(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, tr)
+		})
+	}
+}
+
+func TestDNSClientTLS(t *testing.T) {
+	t.Parallel()
+	cert, caFile := newTestCertificate(t, "dns.test")
+	addr := startTLSServer(t, cert)
+
+	tlsConfig, err := newTLSConfig("dns.test", caFile)
+	require.NoError(t, err)
+	c := newDNSClient(&dnsRequest{transport: transportTLS, tlsConfig: tlsConfig})
+	defer c.close()
+
+	query := new(dns.Msg)
+	query.SetQuestion("thebeat.co.", dns.TypeA)
+
+	response, _, err := c.query(query, addr)
+	require.NoError(t, err)
+	require.Len(t, response.Answer, 1)
+	assert.Positive(t, c.lastExchange().handshake)
+
+	// The second query should reuse the connection without a new handshake
+	conn := c.conn
+	_, _, err = c.query(query, addr)
+	require.NoError(t, err)
+	assert.Same(t, conn, c.conn)
+	assert.Zero(t, c.lastExchange().handshake)
+
+	// A connection closed by the resolver should be replaced transparently
+	c.conn.Close()
+	_, _, err = c.query(query, addr)
+	require.NoError(t, err)
+	assert.Positive(t, c.lastExchange().handshake)
+}
+
+func TestDNSClientTLSResolverAsServerName(t *testing.T) {
+	t.Parallel()
+	cert, caFile := newTestCertificate(t, "dns.test")
+	addr := startTLSServer(t, cert)
+
+	tlsConfig, err := newTLSConfig("", caFile)
+	require.NoError(t, err)
+	c := newDNSClient(&dnsRequest{transport: transportTLS, tlsConfig: tlsConfig})
+	defer c.close()
+
+	query := new(dns.Msg)
+	query.SetQuestion("thebeat.co.", dns.TypeA)
+
+	_, _, err = c.query(query, addr)
+	require.NoError(t, err)
+	assert.Empty(t, tlsConfig.ServerName)
+}
+
+func TestDNSClientTLSWrongServerName(t *testing.T) {
+	t.Parallel()
+	cert, caFile := newTestCertificate(t, "dns.test")
+	addr := startTLSServer(t, cert)
+
+	tlsConfig, err := newTLSConfig("other.test", caFile)
+	require.NoError(t, err)
+	c := newDNSClient(&dnsRequest{transport: transportTLS, tlsConfig: tlsConfig})
+	defer c.close()
+
+	query := new(dns.Msg)
+	query.SetQuestion("thebeat.co.", dns.TypeA)
+
+	_, _, err = c.query(query, addr)
+	require.Error(t, err)
+	assert.Nil(t, c.conn)
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	t.Parallel()
+	_, caFile := newTestCertificate(t, "dns.test")
+
+	cfg, err := newTLSConfig("dns.test", caFile)
+	require.NoError(t, err)
+	assert.Equal(t, "dns.test", cfg.ServerName)
+	assert.NotNil(t, cfg.RootCAs)
+
+	cfg, err = newTLSConfig("dns.test", "")
+	require.NoError(t, err)
+	assert.Nil(t, cfg.RootCAs)
+
+	_, err = newTLSConfig("dns.test", filepath.Join(t.TempDir(), "missing.pem"))
+	require.Error(t, err)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, []byte("not a certificate"), 0o600))
+	_, err = newTLSConfig("dns.test", empty)
+	require.Error(t, err)
+}
+
+// truncatingHandler answers with a single truncated A record over UDP
+// and with the full answer set over TCP.
+func truncatingHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	count := 3
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		m.Truncated = true
+		count = 1
+	}
+	for i := 1; i <= count; i++ {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.IPv4(127, 0, 0, byte(i)),
+		})
+	}
+	_ = w.WriteMsg(m)
+}
+
+// startUDPAndTCPServer starts local UDP and TCP DNS servers on the same
+// port and returns their address.
+func startUDPAndTCPServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		t.Skipf("Cannot listen on TCP port of %s: %v", pc.LocalAddr(), err)
+	}
+
+	for _, server := range []*dns.Server{{PacketConn: pc, Handler: handler}, {Listener: l, Handler: handler}} {
+		started := make(chan struct{})
+		server.NotifyStartedFunc = func() { close(started) }
+		go func() {
+			_ = server.ActivateAndServe()
+		}()
+		<-started
+		t.Cleanup(func() {
+			_ = server.Shutdown()
+		})
+	}
+
+	return pc.LocalAddr().String()
+}
+
+func TestDNSClientTruncatedFallback(t *testing.T) {
+	t.Parallel()
+	addr := startUDPAndTCPServer(t, truncatingHandler)
+	query := new(dns.Msg)
+	query.SetQuestion("thebeat.co.", dns.TypeA)
+
+	c := newDNSClient(&dnsRequest{transport: transportUDP})
+	response, _, err := c.query(query, addr)
+	require.NoError(t, err)
+	assert.False(t, response.Truncated)
+	assert.Len(t, response.Answer, 3)
+	assert.True(t, c.lastExchange().truncated)
+
+	c = newDNSClient(&dnsRequest{transport: transportTCP})
+	response, _, err = c.query(query, addr)
+	require.NoError(t, err)
+	assert.Len(t, response.Answer, 3)
+	assert.False(t, c.lastExchange().truncated)
+}
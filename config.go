@@ -3,8 +3,16 @@
 package main
 
 import (
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/miekg/dns"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
@@ -13,6 +21,7 @@ import (
 // YamlRequests encapsulates yaml objects that represent the
 // array that holds the requests with the monitoring domains.
 type YamlRequests struct {
+	Defaults YamlDefaults  `yaml:"defaults"`
 	Requests []YamlRequest `yaml:"requests"`
 }
 
@@ -24,42 +33,157 @@ func (r *YamlRequests) getCleanRequests() ([]*dnsStream, error) {
 	if len(r.Requests) == 0 {
 		return []*dnsStream{}, errors.Errorf("Yaml configuration seems empty or malformed, cannot proceed with no valid requests")
 	}
+	cleanRequests, requestErrors := r.cleanRequests()
+	for _, err := range requestErrors {
+		log.Error(err.Error())
+	}
+	if len(cleanRequests) == 0 {
+		return []*dnsStream{}, errors.Errorf("No valid requests found inside the request sections coming from yaml config")
+	}
+
+	return cleanRequests, nil
+}
+
+// requestError is the error of an invalid request of the yaml config.
+type requestError struct {
+	index  int
+	domain string
+	err    error
+}
+
+func (e *requestError) Error() string {
+	return fmt.Sprintf("requests[%d] (domain:<%s>): %v", e.index, e.domain, e.err)
+}
+
+// cleanRequests cleans every request of the yaml config with the defaults
+// applied, returning the streams of the valid ones along with the errors
+// of the invalid ones.
+func (r *YamlRequests) cleanRequests() ([]*dnsStream, []*requestError) {
 	cleanRequests := []*dnsStream{}
+	var requestErrors []*requestError
+	// The cgo resolver needs to be picked before any resolver address of
+	// the requests is looked up
 	for _, req := range r.Requests {
+		if req = r.Defaults.apply(req); req.usesCgoResolver() {
+			useCgoResolver()
+			break
+		}
+	}
+	for i, req := range r.Requests {
+		req = r.Defaults.apply(req)
 		c, err := req.getCleanRequest()
 		if err != nil {
-			log.Error(err.Error())
+			requestErrors = append(requestErrors, &requestError{index: i, domain: req.Domain, err: err})
 			continue
 		}
-		cleanRequests = append(cleanRequests, c)
+		c.request.index = i
+		cleanRequests = append(cleanRequests, c.perResolver()...)
 	}
-	if len(cleanRequests) == 0 {
-		return []*dnsStream{}, errors.Errorf("No valid requests found inside the request sections coming from yaml config")
+	return cleanRequests, requestErrors
+}
+
+// YamlDefaults encapsulates yaml objects that represent the settings every
+// request inherits, unless it sets them itself.
+type YamlDefaults struct {
+	Interval  *int              `yaml:"interval"`
+	Timeout   *string           `yaml:"timeout"`
+	Resolver  *string           `yaml:"resolver"`
+	Transport *string           `yaml:"transport"`
+	Match     *string           `yaml:"match"`
+	Labels    map[string]string `yaml:"labels"`
+}
+
+// fallbackInterval sets the interval of the defaults to the given one,
+// unless the defaults set one themselves.
+func (d *YamlDefaults) fallbackInterval(interval int) {
+	if d.Interval == nil {
+		d.Interval = &interval
 	}
+}
 
-	return cleanRequests, nil
+// apply returns the request with the settings it doesn't set taken from
+// the defaults. Labels are merged, with the ones of the request taking
+// precedence.
+func (d *YamlDefaults) apply(r YamlRequest) YamlRequest {
+	if r.Interval == nil {
+		r.Interval = d.Interval
+	}
+	if r.Timeout == nil {
+		r.Timeout = d.Timeout
+	}
+	if r.Transport == nil {
+		r.Transport = d.Transport
+	}
+	if r.Match == nil {
+		r.Match = d.Match
+	}
+	// The system transport has no resolver to send the query to
+	if r.Resolver == nil && len(r.Resolvers) == 0 && (r.Transport == nil || *r.Transport != string(transportSystem)) {
+		r.Resolver = d.Resolver
+	}
+	if len(d.Labels) > 0 {
+		labels := maps.Clone(d.Labels)
+		maps.Copy(labels, r.Labels)
+		r.Labels = labels
+	}
+	return r
 }
 
 // YamlRequest encapsulates yaml objects that represent single
 // requests for a domain that we want to monitor.
 type YamlRequest struct {
-	Domain               string   `yaml:"domain"`
-	QueryType            string   `yaml:"queryType"`
-	Resolver             *string  `yaml:"resolver"`
-	ExpectedResponse     []string `yaml:"expectedRespone"`
-	ExpectedResponseCode *string  `yaml:"expectedResponseCode"`
-	Interval             *int     `yaml:"interval"`
+	Domain                string            `yaml:"domain"`
+	QueryType             string            `yaml:"queryType"`
+	Resolver              *string           `yaml:"resolver"`
+	Resolvers             []string          `yaml:"resolvers"`
+	ExpectedResponse      []string          `yaml:"expectedResponse"`
+	ExpectedResponseCode  *string           `yaml:"expectedResponseCode"`
+	Interval              *int              `yaml:"interval"`
+	Match                 *string           `yaml:"match"`
+	MinAnswers            *int              `yaml:"minAnswers"`
+	MaxAnswers            *int              `yaml:"maxAnswers"`
+	MinTTL                *int              `yaml:"minTTL"`
+	MaxTTL                *int              `yaml:"maxTTL"`
+	Authoritative         *bool             `yaml:"authoritative"`
+	Authenticated         *bool             `yaml:"authenticated"`
+	RecursionAvailable    *bool             `yaml:"recursionAvailable"`
+	Truncated             *bool             `yaml:"truncated"`
+	ExpectedAuthority     []string          `yaml:"expectedAuthority"`
+	ExpectedAdditional    []string          `yaml:"expectedAdditional"`
+	Transport             *string           `yaml:"transport"`
+	TLSServerName         *string           `yaml:"tlsServerName"`
+	TLSCAFile             *string           `yaml:"tlsCAFile"`
+	HTTPMethod            *string           `yaml:"httpMethod"`
+	DNSSEC                *bool             `yaml:"dnssec"`
+	DNSSECValidation      *string           `yaml:"dnssecValidation"`
+	TrustAnchors          []string          `yaml:"trustAnchors"`
+	Check                 *string           `yaml:"check"`
+	RRSIGExpiryWarning    *string           `yaml:"rrsigExpiryWarning"`
+	Quorum                *int              `yaml:"quorum"`
+	UseSearchList         *bool             `yaml:"useSearchList"`
+	SystemResolver        *string           `yaml:"systemResolver"`
+	Timeout               *string           `yaml:"timeout"`
+	Retries               *int              `yaml:"retries"`
+	RetryBackoff          *string           `yaml:"retryBackoff"`
+	Jitter                *string           `yaml:"jitter"`
+	Labels                map[string]string `yaml:"labels"`
+	ExpectedExtendedError *string           `yaml:"expectedExtendedError"`
+	ForbidExtendedErrors  []string          `yaml:"forbidExtendedErrors"`
 }
 
 // getCleanRequest holds the logic of cleaning a request for a domain
 // coming from the yaml config and returns a dnsStream structure that
 // can be used further in our code.
 func (r *YamlRequest) getCleanRequest() (*dnsStream, error) {
-	dr := &dnsRequest{r.Domain, r.QueryType, r.Resolver, r.ExpectedResponse, nil}
+	dr := &dnsRequest{domain: r.Domain, queryType: r.QueryType, expectedResponse: r.ExpectedResponse}
 
 	if dr.queryType == "" {
 		dr.queryType = "A"
 	}
+	dr.queryType = strings.ToUpper(dr.queryType)
+	if qtype, ok := dns.StringToType[dr.queryType]; !ok || qtype == dns.TypeNone {
+		return nil, errors.Errorf("%s is not a supported DNS query type", r.QueryType)
+	}
 
 	if dr.domain == "" {
 		return nil, errors.New("domain needs to be a valid domain and not empty string")
@@ -72,26 +196,428 @@ func (r *YamlRequest) getCleanRequest() (*dnsStream, error) {
 		}
 		dr.expectedResponseCode = &rCode
 	}
-	interval := 360 // Default interval loop at 5min
+
+	if r.UseSearchList != nil {
+		dr.useSearchList = *r.UseSearchList
+	}
+
+	if err := r.cleanExtendedErrors(dr); err != nil {
+		return nil, err
+	}
+
+	dr.resolvers = r.getResolvers()
+	if len(dr.resolvers) == 1 {
+		dr.resolver = &dr.resolvers[0]
+	}
+
+	mode := ""
+	if r.Match != nil {
+		mode = *r.Match
+	}
+	match, err := newAnswerMatch(mode, r.ExpectedResponse, r.MinAnswers, r.MaxAnswers)
+	if err != nil {
+		return nil, err
+	}
+	dr.match = match
+
+	if err := newTTLRange(r.MinTTL, r.MaxTTL); err != nil {
+		return nil, err
+	}
+	dr.minTTL, dr.maxTTL = r.MinTTL, r.MaxTTL
+
+	if err := r.cleanHeader(dr); err != nil {
+		return nil, err
+	}
+
+	if err := r.cleanTransport(dr); err != nil {
+		return nil, err
+	}
+
+	if err := cleanResolverAddrs(dr); err != nil {
+		return nil, err
+	}
+
+	if err := r.cleanDNSSEC(dr); err != nil {
+		return nil, err
+	}
+
+	if err := r.cleanCheck(dr); err != nil {
+		return nil, err
+	}
+
+	if err := r.cleanRetries(dr); err != nil {
+		return nil, err
+	}
+	if dr.labels, err = newLabels(r.Labels); err != nil {
+		return nil, err
+	}
+
+	interval := defaultInterval
 	if r.Interval != nil {
 		interval = *r.Interval
 	}
+	if interval <= 0 {
+		return nil, errors.Errorf("interval needs to be a positive number of seconds, got %d", interval)
+	}
+
+	stream := newDNSStream(dr, interval)
+	if r.Jitter != nil {
+		if stream.jitter, err = newJitter(*r.Jitter, interval); err != nil {
+			return nil, err
+		}
+	}
+
+	return stream, nil
+}
+
+// getResolvers returns the resolvers of the request, merging the single
+// resolver with the list of resolvers and dropping duplicates.
+func (r *YamlRequest) getResolvers() []string {
+	var resolvers []string
+	if r.Resolver != nil {
+		resolvers = append(resolvers, *r.Resolver)
+	}
+	for _, res := range r.Resolvers {
+		if !slices.Contains(resolvers, res) {
+			resolvers = append(resolvers, res)
+		}
+	}
+	return resolvers
+}
+
+// cleanTransport validates the transport settings of the request and
+// fills them in the given dnsRequest.
+func (r *YamlRequest) cleanTransport(dr *dnsRequest) error {
+	name := ""
+	if r.Transport != nil {
+		name = *r.Transport
+	}
+	t, err := newTransport(name)
+	if err != nil {
+		return err
+	}
+	dr.transport = t
+
+	if t == transportSystem {
+		return r.cleanSystemTransport(dr)
+	}
+	if t != transportTLS && t != transportHTTPS {
+		return nil
+	}
+	if len(dr.resolvers) == 0 {
+		return errors.Errorf("a resolver is required for the %s transport", t)
+	}
+
+	if t == transportHTTPS {
+		for _, res := range dr.resolvers {
+			if _, err := newDoHURL(res); err != nil {
+				return err
+			}
+		}
+		method := ""
+		if r.HTTPMethod != nil {
+			method = *r.HTTPMethod
+		}
+		if dr.httpMethod, err = newHTTPMethod(method); err != nil {
+			return err
+		}
+	}
+
+	// Without a server name, the host of each resolver is used
+	serverName := ""
+	if r.TLSServerName != nil {
+		serverName = *r.TLSServerName
+	}
+	caFile := ""
+	if r.TLSCAFile != nil {
+		caFile = *r.TLSCAFile
+	}
+	tlsConfig, err := newTLSConfig(serverName, caFile)
+	if err != nil {
+		return err
+	}
+	dr.tlsConfig = tlsConfig
+
+	return nil
+}
+
+// cleanSystemTransport validates the settings of a request looked up with
+// the system resolver, which can't be sent to a resolver of our choice.
+func (r *YamlRequest) cleanSystemTransport(dr *dnsRequest) error {
+	if len(dr.resolvers) > 0 {
+		return errors.Errorf("resolvers cannot be set for the %s transport, the resolvers of the system are used", transportSystem)
+	}
+	if !slices.Contains(systemQueryTypes, dr.qtype()) {
+		return errors.Errorf("%s records cannot be looked up with the %s transport", dr.queryType, transportSystem)
+	}
+	if (r.DNSSEC != nil && *r.DNSSEC) || r.DNSSECValidation != nil {
+		return errors.Errorf("DNSSEC is not supported by the %s transport", transportSystem)
+	}
+	if r.UseSearchList != nil && *r.UseSearchList {
+		return errors.Errorf("useSearchList cannot be set for the %s transport, the system resolver applies the search list itself", transportSystem)
+	}
+	if dr.flags != (headerFlags{}) || len(dr.expectedAuthority) > 0 || len(dr.expectedAdditional) > 0 {
+		return errors.Errorf("header flags and the authority and additional sections cannot be checked for the %s transport, which only gets the answers", transportSystem)
+	}
+	if dr.minTTL != nil || dr.maxTTL != nil {
+		return errors.Errorf("minTTL and maxTTL cannot be set for the %s transport, which doesn't tell the TTLs", transportSystem)
+	}
+	if dr.expectedExtendedError != nil {
+		return errors.Errorf("expectedExtendedError cannot be set for the %s transport, which gets no extended DNS errors", transportSystem)
+	}
+
+	name := ""
+	if r.SystemResolver != nil {
+		name = *r.SystemResolver
+	}
+	mode, err := newNetResolverMode(name)
+	if err != nil {
+		return err
+	}
+	dr.netResolverMode = mode
+	if mode == netResolverCgo {
+		useCgoResolver()
+	}
 
-	return newDNSStream(dr, interval), nil
+	return nil
 }
 
+// usesCgoResolver checks if the request looks its domain up with the cgo
+// system resolver.
+func (r *YamlRequest) usesCgoResolver() bool {
+	return r.Transport != nil && strings.EqualFold(*r.Transport, string(transportSystem)) &&
+		r.SystemResolver != nil && strings.EqualFold(*r.SystemResolver, string(netResolverCgo))
+}
+
+// cleanHeader validates the expected header flags and records of the
+// authority and additional sections and fills them in the given dnsRequest.
+func (r *YamlRequest) cleanHeader(dr *dnsRequest) error {
+	dr.flags = headerFlags{
+		authoritative:      r.Authoritative,
+		authenticated:      r.Authenticated,
+		recursionAvailable: r.RecursionAvailable,
+		truncated:          r.Truncated,
+	}
+
+	var err error
+	if dr.expectedAuthority, err = newSectionRecords("expectedAuthority", r.ExpectedAuthority); err != nil {
+		return err
+	}
+	if dr.expectedAdditional, err = newSectionRecords("expectedAdditional", r.ExpectedAdditional); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cleanExtendedErrors validates the Extended DNS Errors the response of the
+// request needs to have or can't have, and fills them in the given
+// dnsRequest.
+func (r *YamlRequest) cleanExtendedErrors(dr *dnsRequest) error {
+	if r.ExpectedExtendedError != nil {
+		code, err := newExtendedErrorCode(*r.ExpectedExtendedError)
+		if err != nil {
+			return err
+		}
+		dr.expectedExtendedError = &code
+	}
+	for _, e := range r.ForbidExtendedErrors {
+		code, err := newExtendedErrorCode(e)
+		if err != nil {
+			return err
+		}
+		if dr.expectedExtendedError != nil && *dr.expectedExtendedError == code {
+			return errors.Errorf("extended DNS error %s cannot be both expected and forbidden", e)
+		}
+		dr.forbiddenExtendedErrors = append(dr.forbiddenExtendedErrors, code)
+	}
+	return nil
+}
+
+// cleanResolverAddrs parses the addresses of the resolvers of the request,
+// resolving the ones configured by hostname.
+func cleanResolverAddrs(dr *dnsRequest) error {
+	if dr.transport == transportHTTPS || dr.transport == transportSystem || len(dr.resolvers) == 0 {
+		return nil
+	}
+
+	dr.resolverAddrs = make(map[string]*resolverAddr, len(dr.resolvers))
+	for _, res := range dr.resolvers {
+		addr, err := newResolverAddr(res, dr.transport.defaultPort())
+		if err != nil {
+			return err
+		}
+		if err := addr.resolve(); err != nil {
+			log.Errorf("%v, it will be resolved when queried", err)
+		}
+		dr.resolverAddrs[res] = addr
+	}
+
+	return nil
+}
+
+// cleanDNSSEC validates the DNSSEC settings of the request and fills
+// them in the given dnsRequest. Validation implies setting the DO bit.
+func (r *YamlRequest) cleanDNSSEC(dr *dnsRequest) error {
+	if r.DNSSEC != nil {
+		dr.dnssec = *r.DNSSEC
+	}
+
+	name := ""
+	if r.DNSSECValidation != nil {
+		name = *r.DNSSECValidation
+	}
+	mode, err := newDNSSECMode(name)
+	if err != nil {
+		return err
+	}
+	if mode == "" {
+		return nil
+	}
+
+	anchors, err := newTrustAnchors(r.TrustAnchors)
+	if err != nil {
+		return err
+	}
+	dr.dnssec = true
+	dr.dnssecValidation = mode
+	dr.trustAnchors = anchors
+
+	return nil
+}
+
+// cleanCheck validates the check type of the request and its settings
+// and fills them in the given dnsRequest.
+func (r *YamlRequest) cleanCheck(dr *dnsRequest) error {
+	name := ""
+	if r.Check != nil {
+		name = *r.Check
+	}
+	check, err := newCheckType(name)
+	if err != nil {
+		return err
+	}
+	dr.check = check
+
+	if check == checkRRSIGExpiry {
+		if dr.transport == transportSystem {
+			return errors.Errorf("the %s check is not supported by the %s transport", check, transportSystem)
+		}
+		threshold := ""
+		if r.RRSIGExpiryWarning != nil {
+			threshold = *r.RRSIGExpiryWarning
+		}
+		if dr.rrsigExpiryWarning, err = newRRSIGExpiryWarning(threshold); err != nil {
+			return err
+		}
+		// RRSIG records are only returned when the DO bit is set
+		dr.dnssec = true
+	}
+
+	if check == checkConsensus {
+		if dr.quorum, err = newQuorum(r.Quorum, len(dr.resolvers)); err != nil {
+			return err
+		}
+		if dr.dnssecValidation != "" {
+			return errors.New("DNSSEC validation is not supported by consensus checks")
+		}
+		if dr.useSearchList {
+			return errors.New("useSearchList is not supported by consensus checks, which query the domain as it is")
+		}
+	}
+
+	return nil
+}
+
+// cleanRetries validates the timeout and retry settings of the request
+// and fills them in the given dnsRequest.
+func (r *YamlRequest) cleanRetries(dr *dnsRequest) error {
+	var timeout, backoff string
+	if r.Timeout != nil {
+		timeout = *r.Timeout
+	}
+	if r.RetryBackoff != nil {
+		backoff = *r.RetryBackoff
+	}
+
+	var err error
+	if dr.timeout, err = newPositiveDuration("timeout", timeout, 0); err != nil {
+		return err
+	}
+	if dr.retries, err = newRetries(r.Retries); err != nil {
+		return err
+	}
+	if dr.retryBackoff, err = newPositiveDuration("retryBackoff", backoff, defaultRetryBackoff); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// labelNameRegexp matches the names Prometheus accepts for labels.
+var labelNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// newLabels validates the custom labels of a request, whose names can't
+// clash with the labels the metrics already carry.
+func newLabels(labels map[string]string) (map[string]string, error) {
+	for name := range labels {
+		if !labelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, errors.Errorf("%s is not a valid label name", name)
+		}
+		if isReservedLabelName(name) {
+			return nil, errors.Errorf("%s is a label of the metrics already and cannot be set", name)
+		}
+	}
+	return labels, nil
+}
+
+// requestLabelNames returns the sorted names of the custom labels set by any
+// of the requests.
+func requestLabelNames(requests []*dnsStream) []string {
+	var names []string
+	for _, r := range requests {
+		for name := range r.request.labels {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// newJitter parses the jitter of a request, which can't be longer than its
+// interval.
+func newJitter(value string, interval int) (time.Duration, error) {
+	jitter, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "%s is not a valid duration for jitter", value)
+	}
+	if jitter < 0 || jitter > time.Duration(interval)*time.Second {
+		return 0, errors.Errorf("jitter needs to be between 0 and the interval of %ds, got %s", interval, value)
+	}
+	return jitter, nil
+}
+
+// defaultInterval is how often requests run, in seconds, when neither the
+// config nor the environment set an interval.
+const defaultInterval = 30
+
 type config struct {
 	appPort          int
 	logLevel         string
+	startOffset      time.Duration
 	watchdogRequests []*dnsStream
 }
 
 func newConfig() (*config, error) {
 	initViper()
-	r, err := getYamlConfig()
+	r, err := getYamlConfig("")
 	if err != nil {
 		return nil, errors.Wrap(err, "Couldn't get a yaml config")
 	}
+	if err := r.fallbackToEnvironment(); err != nil {
+		return nil, err
+	}
 	cleanDNSRequests, err := r.getCleanRequests()
 	if err != nil {
 		return nil, errors.Wrap(err, "Couldn't get a valid yaml config")
@@ -103,13 +629,45 @@ func newConfig() (*config, error) {
 		return nil, errors.New("Couldn't get a valid integer for the DNS_VERIFIER_PORT configuration variable")
 	}
 
+	startOffset, err := time.ParseDuration(viper.GetString("start_offset"))
+	if err != nil || startOffset < 0 {
+		return nil, errors.New("Couldn't get a valid positive duration for the DNS_VERIFIER_START_OFFSET configuration variable")
+	}
+
 	return &config{
 		appPort:          intPort,
 		logLevel:         viper.GetString("log_level"),
+		startOffset:      startOffset,
 		watchdogRequests: cleanDNSRequests,
 	}, nil
 }
 
+// loadYamlConfig reads the yaml config at the path, or the one found in the
+// config paths without a path, for the commands that don't start the
+// watchdog.
+func loadYamlConfig(path string) (*YamlRequests, error) {
+	initViper()
+	r, err := getYamlConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.fallbackToEnvironment(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// fallbackToEnvironment makes the requests fall back to the settings of the
+// environment for the ones neither they nor the defaults set.
+func (r *YamlRequests) fallbackToEnvironment() error {
+	interval, err := strconv.Atoi(viper.GetString("interval"))
+	if err != nil || interval <= 0 {
+		return errors.New("Couldn't get a valid positive integer for the DNS_VERIFIER_INTERVAL configuration variable")
+	}
+	r.Defaults.fallbackInterval(interval)
+	return nil
+}
+
 // initViper initializes all viper configuration that we need.
 func initViper() {
 	// Set global options
@@ -122,27 +680,31 @@ func initViper() {
 	// Set default for our existing env variables
 	viper.SetDefault("APP_PORT", "3333")
 	viper.SetDefault("LOG_LEVEL", "DEBUG")
-	viper.SetDefault("INTERVAL", 30)
+	viper.SetDefault("INTERVAL", defaultInterval)
+	viper.SetDefault("START_OFFSET", "0s")
+	viper.SetDefault("STRICT_CONFIG", true)
 
 	// Enable VIPER to read Environment Variables
 	viper.AutomaticEnv()
 }
 
 // getYamlConfig reads the config yaml file that contains the user's
-// requests for monitoring domains. After successfully reading the file
-// the funciton return a YamlRequests struct that contains all info from
-// the file.
-func getYamlConfig() (*YamlRequests, error) {
+// requests for monitoring domains, either the one at the given path or,
+// without a path, the one found in the config paths. After successfully
+// reading the file the funciton return a YamlRequests struct that contains
+// all info from the file.
+func getYamlConfig(path string) (*YamlRequests, error) {
+	if path != "" {
+		viper.SetConfigFile(path)
+	}
 	if err := viper.ReadInConfig(); err != nil {
 		return nil, errors.Wrap(err, "Error reading config file")
 	}
 
-	var yr YamlRequests
-
-	err := viper.Unmarshal(&yr)
+	data, err := os.ReadFile(viper.ConfigFileUsed())
 	if err != nil {
-		return nil, errors.Wrap(err, "Unable to decode config yaml into struct")
+		return nil, errors.Wrap(err, "Error reading config file")
 	}
 
-	return &yr, nil
+	return parseYamlConfig(data, viper.GetBool("strict_config"))
 }
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCleanRequestQueryType(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		queryType string
+		expected  string
+		wantErr   bool
+	}{
+		"Default to A":       {"", "A", false},
+		"Known type":         {"SRV", "SRV", false},
+		"Lowercase type":     {"https", "HTTPS", false},
+		"Unknown type":       {"FOO", "", true},
+		"None is not a type": {"None", "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := &YamlRequest{Domain: "thebeat.co", QueryType: tt.queryType}
+			s, err := r.getCleanRequest()
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, s.request.queryType)
+		})
+	}
+}
+
+func TestGetCleanRequestMatch(t *testing.T) {
+	t.Parallel()
+	valid, invalid := "cidr", "sorta"
+	r := &YamlRequest{Domain: "thebeat.co", Match: &valid, ExpectedResponse: []string{"10.0.0.0/8"}}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, matchCIDR, s.request.match.mode)
+
+	r = &YamlRequest{Domain: "thebeat.co", Match: &valid, ExpectedResponse: []string{"10.0.0.1"}}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Match: &invalid}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestTransport(t *testing.T) {
+	t.Parallel()
+	tls, udp, unknown := "tls", "udp", "quic"
+	resolver, serverName := "1.1.1.1", "cloudflare-dns.com"
+
+	r := &YamlRequest{Domain: "thebeat.co", Transport: &tls, Resolver: &resolver, TLSServerName: &serverName}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, transportTLS, s.request.transport)
+	assert.Equal(t, serverName, s.request.tlsConfig.ServerName)
+
+	// The host of each resolver is used as server name when none is given
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &tls, Resolver: &resolver}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Empty(t, s.request.tlsConfig.ServerName)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &tls}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &udp}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, transportUDP, s.request.transport)
+	assert.Nil(t, s.request.tlsConfig)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &unknown}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestSystem(t *testing.T) {
+	t.Parallel()
+	system, goResolver, soa, resolver, validation, rrsig := "system", "go", "SOA", "8.8.8.8", "report", "rrsig-expiry"
+	useSearchList := true
+
+	r := &YamlRequest{Domain: "thebeat.co", Transport: &system, SystemResolver: &goResolver}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, transportSystem, s.request.transport)
+	assert.Equal(t, netResolverGo, s.request.netResolverMode)
+	assert.IsType(t, &systemClient{}, newResolverClient(&s.request))
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &system}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, netResolverAuto, s.request.netResolverMode)
+
+	for name, r := range map[string]*YamlRequest{
+		"Resolver":           {Domain: "thebeat.co", Transport: &system, Resolver: &resolver},
+		"Unsupported type":   {Domain: "thebeat.co", Transport: &system, QueryType: soa},
+		"DNSSEC validation":  {Domain: "thebeat.co", Transport: &system, DNSSECValidation: &validation},
+		"Unknown resolver":   {Domain: "thebeat.co", Transport: &system, SystemResolver: &soa},
+		"Search list":        {Domain: "thebeat.co", Transport: &system, UseSearchList: &useSearchList},
+		"RRSIG expiry check": {Domain: "thebeat.co", Transport: &system, Check: &rrsig},
+	} {
+		_, err = r.getCleanRequest()
+		require.Error(t, err, name)
+	}
+}
+
+func TestGetCleanRequestDoH(t *testing.T) {
+	t.Parallel()
+	https, post, put := "https", "post", "PUT"
+	url, notURL := "https://dns.google/dns-query{?dns}", "8.8.8.8"
+
+	r := &YamlRequest{Domain: "thebeat.co", Transport: &https, Resolver: &url, HTTPMethod: &post}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, transportHTTPS, s.request.transport)
+	assert.Equal(t, "POST", s.request.httpMethod)
+	assert.Empty(t, s.request.tlsConfig.ServerName)
+	conf, err := s.constructResolvers()
+	require.NoError(t, err)
+	assert.Equal(t, []string{url}, conf.servers)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &https, Resolver: &url}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "GET", s.request.httpMethod)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &https, Resolver: &url, HTTPMethod: &put}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &https, Resolver: &notURL}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestDNSSEC(t *testing.T) {
+	t.Parallel()
+	enabled, enforce, unknown := true, "enforce", "strict"
+	anchor := "example. 3600 IN DS 12345 13 2 0123456789ABCDEF"
+
+	r := &YamlRequest{Domain: "thebeat.co", DNSSEC: &enabled}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.True(t, s.request.dnssec)
+	assert.Empty(t, s.request.dnssecValidation)
+
+	// Validation implies the DO bit and falls back to the root trust anchor
+	r = &YamlRequest{Domain: "thebeat.co", DNSSECValidation: &enforce}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.True(t, s.request.dnssec)
+	assert.Equal(t, dnssecEnforce, s.request.dnssecValidation)
+	require.Len(t, s.request.trustAnchors, 1)
+	assert.Equal(t, ".", s.request.trustAnchors[0].Hdr.Name)
+
+	r = &YamlRequest{Domain: "thebeat.co", DNSSECValidation: &enforce, TrustAnchors: []string{anchor}}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "example.", s.request.trustAnchors[0].Hdr.Name)
+
+	r = &YamlRequest{Domain: "thebeat.co", DNSSECValidation: &unknown}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestCheck(t *testing.T) {
+	t.Parallel()
+	rrsig, warning, unknown := "rrsig-expiry", "48h", "nothing"
+
+	r := &YamlRequest{Domain: "thebeat.co"}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, checkAnswer, s.request.check)
+
+	r = &YamlRequest{Domain: "thebeat.co", QueryType: "SOA", Check: &rrsig, RRSIGExpiryWarning: &warning}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, checkRRSIGExpiry, s.request.check)
+	assert.Equal(t, 48*time.Hour, s.request.rrsigExpiryWarning)
+	assert.True(t, s.request.dnssec)
+
+	r = &YamlRequest{Domain: "thebeat.co", Check: &unknown}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestConsensus(t *testing.T) {
+	t.Parallel()
+	consensus, validation := "consensus", "report"
+	useSearchList := true
+
+	r := &YamlRequest{Domain: "thebeat.co", Check: &consensus, Resolvers: []string{"8.8.8.8", "1.1.1.1", "10.0.0.10"}}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, checkConsensus, s.request.check)
+	assert.Equal(t, 2, s.request.quorum)
+
+	r.Quorum = intPtr(3)
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, 3, s.request.quorum)
+
+	r = &YamlRequest{Domain: "thebeat.co", Check: &consensus, Resolvers: []string{"8.8.8.8"}}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Check: &consensus, Resolvers: []string{"8.8.8.8", "1.1.1.1"}, DNSSECValidation: &validation}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Check: &consensus, Resolvers: []string{"8.8.8.8", "1.1.1.1"}, UseSearchList: &useSearchList}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestResolvers(t *testing.T) {
+	t.Parallel()
+	resolver := "8.8.8.8"
+
+	r := &YamlRequest{Domain: "thebeat.co"}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Nil(t, s.request.resolver)
+	streams := s.perResolver()
+	require.Len(t, streams, 1)
+	assert.Equal(t, systemResolverLabel, streams[0].resolverLabel())
+
+	r = &YamlRequest{Domain: "thebeat.co", Resolvers: []string{resolver}}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, resolver, *s.request.resolver)
+	assert.Len(t, s.perResolver(), 1)
+
+	// The single resolver and the list are merged without duplicates
+	r = &YamlRequest{Domain: "thebeat.co", Resolver: &resolver, Resolvers: []string{"1.1.1.1", resolver, "10.0.0.10"}}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	streams = s.perResolver()
+	require.Len(t, streams, 3)
+	for i, expected := range []string{resolver, "1.1.1.1", "10.0.0.10"} {
+		assert.Equal(t, expected, streams[i].resolverLabel())
+		assert.Equal(t, expected, streams[i].labels()["resolver"])
+		assert.Equal(t, "thebeat.co", streams[i].labels()["domain"])
+	}
+}
+
+func TestGetCleanRequestUseSearchList(t *testing.T) {
+	t.Parallel()
+	useSearchList := true
+
+	r := &YamlRequest{Domain: "my-svc"}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.False(t, s.request.useSearchList)
+
+	r = &YamlRequest{Domain: "my-svc", UseSearchList: &useSearchList}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.True(t, s.request.useSearchList)
+}
+
+func TestGetCleanRequestRetries(t *testing.T) {
+	t.Parallel()
+	timeout, backoff, invalid, negative := "2s", "250ms", "soon", "-1s"
+
+	r := &YamlRequest{Domain: "thebeat.co"}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Zero(t, s.request.timeout)
+	assert.Zero(t, s.request.retries)
+	assert.Equal(t, defaultRetryBackoff, s.request.retryBackoff)
+
+	r = &YamlRequest{Domain: "thebeat.co", Timeout: &timeout, Retries: intPtr(2), RetryBackoff: &backoff}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, 2*time.Second, s.request.timeout)
+	assert.Equal(t, 2, s.request.retries)
+	assert.Equal(t, 250*time.Millisecond, s.request.retryBackoff)
+
+	for name, r := range map[string]*YamlRequest{
+		"Invalid timeout":  {Domain: "thebeat.co", Timeout: &invalid},
+		"Negative timeout": {Domain: "thebeat.co", Timeout: &negative},
+		"Negative retries": {Domain: "thebeat.co", Retries: intPtr(-1)},
+		"Invalid backoff":  {Domain: "thebeat.co", RetryBackoff: &invalid},
+	} {
+		_, err = r.getCleanRequest()
+		require.Error(t, err, name)
+	}
+}
+
+func TestGetCleanRequestJitter(t *testing.T) {
+	t.Parallel()
+	jitter, tooLong, invalid := "30s", "2m", "-1s"
+
+	r := &YamlRequest{Domain: "thebeat.co", Interval: intPtr(60), Jitter: &jitter}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, s.jitter)
+
+	r.Jitter = &tooLong
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r.Jitter = &invalid
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestResolverAddrs(t *testing.T) {
+	t.Parallel()
+	tls, invalid := "tls", "10.0.0.1:dns"
+
+	r := &YamlRequest{Domain: "thebeat.co", Resolvers: []string{"10.0.0.1:5353", "2001:db8::1", "[2001:db8::2]:5353"}}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	for i, expected := range []string{"10.0.0.1:5353", "[2001:db8::1]:53", "[2001:db8::2]:5353"} {
+		assert.Equal(t, expected, s.request.resolverAddress(s.request.resolvers[i]))
+	}
+
+	// The default port follows the transport
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &tls, Resolvers: []string{"2001:db8::1"}}
+	s, err = r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:853", s.request.resolverAddress("2001:db8::1"))
+
+	r = &YamlRequest{Domain: "thebeat.co", Resolver: &invalid}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequests(t *testing.T) {
+	t.Parallel()
+	yr := &YamlRequests{Requests: []YamlRequest{
+		{Domain: "thebeat.co", Resolvers: []string{"8.8.8.8", "1.1.1.1"}},
+		{Domain: "thebeat.co", QueryType: "FOO"},
+		{Domain: "thebeat.co", QueryType: "NS"},
+	}}
+	streams, err := yr.getCleanRequests()
+	require.NoError(t, err)
+	assert.Len(t, streams, 3)
+
+	yr = &YamlRequests{Requests: []YamlRequest{{Domain: "thebeat.co", QueryType: "FOO"}}}
+	_, err = yr.getCleanRequests()
+	require.Error(t, err)
+
+	yr = &YamlRequests{}
+	_, err = yr.getCleanRequests()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestsDefaults(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		defaults YamlDefaults
+		fallback *int
+		request  YamlRequest
+		expected int
+	}{
+		"Built-in default":         {YamlDefaults{}, nil, YamlRequest{Domain: "thebeat.co"}, defaultInterval},
+		"Environment":              {YamlDefaults{}, intPtr(40), YamlRequest{Domain: "thebeat.co"}, 40},
+		"Defaults over env":        {YamlDefaults{Interval: intPtr(20)}, intPtr(40), YamlRequest{Domain: "thebeat.co"}, 20},
+		"Request over defaults":    {YamlDefaults{Interval: intPtr(20)}, intPtr(40), YamlRequest{Domain: "thebeat.co", Interval: intPtr(10)}, 10},
+		"Request over environment": {YamlDefaults{}, intPtr(40), YamlRequest{Domain: "thebeat.co", Interval: intPtr(10)}, 10},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			yr := &YamlRequests{Defaults: tt.defaults, Requests: []YamlRequest{tt.request}}
+			if tt.fallback != nil {
+				yr.Defaults.fallbackInterval(*tt.fallback)
+			}
+			streams, err := yr.getCleanRequests()
+			require.NoError(t, err)
+			require.Len(t, streams, 1)
+			assert.Equal(t, tt.expected, streams[0].interval)
+		})
+	}
+}
+
+func TestYamlDefaultsApply(t *testing.T) {
+	t.Parallel()
+	timeout, resolver, tcp, subset := "2s", "8.8.8.8", "tcp", "subset"
+	requestTimeout, requestResolver, system, exact := "1s", "1.1.1.1", "system", "exact"
+	d := &YamlDefaults{
+		Timeout:   &timeout,
+		Resolver:  &resolver,
+		Transport: &tcp,
+		Match:     &subset,
+		Labels:    map[string]string{"team": "sre", "env": "prod"},
+	}
+
+	r := d.apply(YamlRequest{Domain: "thebeat.co"})
+	assert.Equal(t, timeout, *r.Timeout)
+	assert.Equal(t, resolver, *r.Resolver)
+	assert.Equal(t, tcp, *r.Transport)
+	assert.Equal(t, subset, *r.Match)
+	assert.Equal(t, map[string]string{"team": "sre", "env": "prod"}, r.Labels)
+
+	r = d.apply(YamlRequest{
+		Domain:    "thebeat.co",
+		Timeout:   &requestTimeout,
+		Resolver:  &requestResolver,
+		Transport: &system,
+		Match:     &exact,
+		Labels:    map[string]string{"team": "dns"},
+	})
+	assert.Equal(t, requestTimeout, *r.Timeout)
+	assert.Equal(t, requestResolver, *r.Resolver)
+	assert.Equal(t, system, *r.Transport)
+	assert.Equal(t, exact, *r.Match)
+	assert.Equal(t, map[string]string{"team": "dns", "env": "prod"}, r.Labels)
+
+	// A list of resolvers replaces the default resolver
+	r = d.apply(YamlRequest{Domain: "thebeat.co", Resolvers: []string{"1.1.1.1", "9.9.9.9"}})
+	assert.Nil(t, r.Resolver)
+
+	// Requests of the system transport have no resolver
+	r = d.apply(YamlRequest{Domain: "thebeat.co", Transport: &system})
+	assert.Nil(t, r.Resolver)
+	_, err := r.getCleanRequest()
+	require.NoError(t, err)
+}
+
+func TestGetCleanRequestInterval(t *testing.T) {
+	t.Parallel()
+	r := &YamlRequest{Domain: "thebeat.co", Interval: intPtr(0)}
+	_, err := r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestGetCleanRequestLabels(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		labels  map[string]string
+		wantErr bool
+	}{
+		"No labels":          {nil, false},
+		"Labels":             {map[string]string{"team": "sre", "env_name": "prod"}, false},
+		"Empty value":        {map[string]string{"team": ""}, false},
+		"Invalid name":       {map[string]string{"team-name": "sre"}, true},
+		"Leading digit":      {map[string]string{"1team": "sre"}, true},
+		"Reserved prefix":    {map[string]string{"__team": "sre"}, true},
+		"Stream label":       {map[string]string{"domain": "thebeat.co"}, true},
+		"Metric extra label": {map[string]string{"rcode": "NOERROR"}, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			r := &YamlRequest{Domain: "thebeat.co", Labels: tt.labels}
+			s, err := r.getCleanRequest()
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.labels, s.request.labels)
+		})
+	}
+}
+
+func TestRequestLabelNames(t *testing.T) {
+	t.Parallel()
+	streams := []*dnsStream{
+		newDNSStream(&dnsRequest{domain: "thebeat.co", labels: map[string]string{"team": "sre", "env": "prod"}}, 30),
+		newDNSStream(&dnsRequest{domain: "thebeat.co"}, 30),
+		newDNSStream(&dnsRequest{domain: "thebeat.co", labels: map[string]string{"team": "dns", "tier": "1"}}, 30),
+	}
+	assert.Equal(t, []string{"env", "team", "tier"}, requestLabelNames(streams))
+	assert.Empty(t, requestLabelNames(streams[1:2]))
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// consensusResult holds what a single resolver answered to the question
+// of a consensus check.
+type consensusResult struct {
+	resolver string
+	response dnsResponse
+	rtt      time.Duration
+	err      error
+}
+
+// key returns a string that is the same for results that agree with each
+// other. Results that failed never agree with any other result.
+func (c *consensusResult) key() string {
+	if c.err != nil {
+		return "error:" + c.resolver
+	}
+	answers := slices.Clone(c.response.answers)
+	slices.Sort(answers)
+	return c.response.code.String() + ":" + strings.Join(answers, ",")
+}
+
+// newQuorum validates the quorum of a consensus check, falling back to a
+// majority of the resolvers when it's not set.
+func newQuorum(quorum *int, resolvers int) (int, error) {
+	if resolvers < 2 {
+		return 0, errors.Errorf("a consensus check needs at least 2 resolvers, got %d", resolvers)
+	}
+	if quorum == nil {
+		return resolvers/2 + 1, nil
+	}
+	if *quorum < 1 || *quorum > resolvers {
+		return 0, errors.Errorf("quorum needs to be between 1 and the number of resolvers(%d), got %d", resolvers, *quorum)
+	}
+	return *quorum, nil
+}
+
+// queryConsensus sends the question of the stream to every resolver of the
+// request and groups the resolvers by the answers they returned. The answers
+// of the biggest group become the response of the stream, so they can be
+// verified like any other response.
+func (d *dnsStream) queryConsensus(dnsClient dnsClientInterface) error {
+	query := d.constructQuery()
+	qtype := d.request.qtype()
+
+	results := make([]*consensusResult, 0, len(d.request.resolvers))
+	d.attempts = 0
+	for _, resolver := range d.request.resolvers {
+		result := &consensusResult{resolver: resolver}
+		var response *dns.Msg
+		attempts, err := d.retry(func() error {
+			var err error
+			if response, result.rtt, err = dnsClient.query(query, d.request.resolverAddress(resolver)); err != nil {
+				d.request.refreshResolver(resolver)
+			}
+			return err
+		})
+		d.attempts += attempts
+		if err != nil {
+			result.err = err
+			log.Infof("Consensus query for domain:<%s> and DNS query type:<%s> to resolver:<%s> failed: %v",
+				d.request.domain, d.request.queryType, resolver, err)
+		} else {
+			result.response.rawResponse = response
+			result.response.code, result.response.answers = parseRawResponse(response, qtype)
+			result.response.ttls = answerTTLs(response, qtype)
+		}
+		results = append(results, result)
+	}
+
+	majority := d.agree(results)
+	if majority == nil {
+		d.clearRun()
+		return errors.Errorf("Consensus queries for: %s failed for every resolver", d.request.domain)
+	}
+
+	d.rtt = 0
+	for _, r := range results {
+		d.rtt = max(d.rtt, r.rtt)
+	}
+	d.response = majority.response
+	d.parseExtendedErrors()
+
+	if d.isResponseLegit() {
+		d.verificationStatus = 1
+	} else {
+		d.verificationStatus = 0
+	}
+
+	return nil
+}
+
+// agree counts how many resolvers agree with each other and returns a
+// result of the biggest group of resolvers that answered, logging the
+// ones that diverge from it.
+func (d *dnsStream) agree(results []*consensusResult) *consensusResult {
+	groups := map[string][]*consensusResult{}
+	var majority []*consensusResult
+	for _, r := range results {
+		k := r.key()
+		groups[k] = append(groups[k], r)
+		if r.err == nil && len(groups[k]) > len(majority) {
+			majority = groups[k]
+		}
+	}
+	if len(majority) == 0 {
+		return nil
+	}
+
+	d.agreeing = len(majority)
+	d.agreement = float64(len(majority)) / float64(len(results))
+
+	for _, r := range results {
+		if r.err != nil || slices.Contains(majority, r) {
+			continue
+		}
+		log.Infof("Resolver:<%s> diverges for domain:<%s> and DNS query type:<%s>, answered code:<%s> answers:<%v> instead of code:<%s> answers:<%v>",
+			r.resolver, d.request.domain, d.request.queryType, r.response.code, r.response.answers, majority[0].response.code, majority[0].response.answers)
+	}
+
+	return majority[0]
+}
+
+// verifyQuorum records a failure when not enough resolvers agree with each
+// other.
+func (d *dnsStream) verifyQuorum() {
+	if d.agreeing < d.request.quorum {
+		d.fail("Only %d of %d resolvers agree for quering domain:<%s> and DNS query type:<%s>, quorum is %d",
+			d.agreeing, len(d.request.resolvers), d.request.domain, d.request.queryType, d.request.quorum)
+	}
+}
+
+// consensusResolverLabel returns the resolver label of consensus checks,
+// which query every resolver of the request.
+func consensusResolverLabel(resolvers []string) string {
+	return strings.Join(resolvers, ",")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// consensusClientTest answers with the A records configured for each
+// resolver address, failing for the resolvers without any.
+type consensusClientTest struct {
+	answers map[string][]string
+}
+
+func (c *consensusClientTest) query(q *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	ips, ok := c.answers[server]
+	if !ok {
+		return nil, 0, errors.Errorf("no answer from %s", server)
+	}
+	m := new(dns.Msg)
+	m.SetReply(q)
+	for _, ip := range ips {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(ip),
+		})
+	}
+	return m, time.Millisecond, nil
+}
+
+func TestQueryConsensus(t *testing.T) {
+	t.Parallel()
+	resolvers := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	tests := map[string]struct {
+		answers            map[string][]string
+		expectedResponse   []string
+		quorum             int
+		agreeing           int
+		verificationStatus float64
+		wantErr            bool
+	}{
+		"All agree": {
+			answers:            map[string][]string{"10.0.0.1:53": {"1.1.1.1", "2.2.2.2"}, "10.0.0.2:53": {"2.2.2.2", "1.1.1.1"}, "10.0.0.3:53": {"1.1.1.1", "2.2.2.2"}},
+			quorum:             3,
+			agreeing:           3,
+			verificationStatus: 1,
+		},
+		"Quorum reached": {
+			answers:            map[string][]string{"10.0.0.1:53": {"1.1.1.1"}, "10.0.0.2:53": {"3.3.3.3"}, "10.0.0.3:53": {"1.1.1.1"}},
+			quorum:             2,
+			agreeing:           2,
+			verificationStatus: 1,
+		},
+		"Quorum not reached": {
+			answers:            map[string][]string{"10.0.0.1:53": {"1.1.1.1"}, "10.0.0.2:53": {"3.3.3.3"}, "10.0.0.3:53": {"4.4.4.4"}},
+			quorum:             2,
+			agreeing:           1,
+			verificationStatus: 0,
+		},
+		"Failed resolver": {
+			answers:            map[string][]string{"10.0.0.1:53": {"1.1.1.1"}, "10.0.0.3:53": {"1.1.1.1"}},
+			quorum:             3,
+			agreeing:           2,
+			verificationStatus: 0,
+		},
+		"Majority with unexpected answer": {
+			answers:            map[string][]string{"10.0.0.1:53": {"1.1.1.1"}, "10.0.0.2:53": {"1.1.1.1"}, "10.0.0.3:53": {"3.3.3.3"}},
+			expectedResponse:   []string{"3.3.3.3"},
+			quorum:             2,
+			agreeing:           2,
+			verificationStatus: 0,
+		},
+		"Every resolver failed": {
+			answers: map[string][]string{},
+			quorum:  2,
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dr := &dnsRequest{domain: "thebeat.co", queryType: "A", resolvers: resolvers, expectedResponse: tt.expectedResponse, check: checkConsensus, quorum: tt.quorum}
+			s := newDNSStream(dr, 100)
+			err := s.query(&consensusClientTest{answers: tt.answers})
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Zero(t, s.agreement)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.agreeing, s.agreeing)
+			assert.InDelta(t, float64(tt.agreeing)/float64(len(resolvers)), s.agreement, 0.0001)
+			assert.InDelta(t, tt.verificationStatus, s.verificationStatus, 0.0001)
+		})
+	}
+}
+
+func TestQueryConsensusResponse(t *testing.T) {
+	t.Parallel()
+	resolvers := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	answers := map[string][]string{"10.0.0.1:53": {"3.3.3.3"}, "10.0.0.2:53": {"1.1.1.1"}, "10.0.0.3:53": {"1.1.1.1"}}
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", resolvers: resolvers, check: checkConsensus, quorum: 2}
+	s := newDNSStream(dr, 100)
+	require.NoError(t, s.query(&consensusClientTest{answers: answers}))
+	assert.Equal(t, []string{"1.1.1.1"}, s.response.answers)
+	assert.Equal(t, NOERROR, s.response.code)
+	assert.Equal(t, "10.0.0.1,10.0.0.2,10.0.0.3", s.resolverLabel())
+	assert.Len(t, s.perResolver(), 1)
+}
+
+func TestQueryConsensusOutageAfterSuccess(t *testing.T) {
+	t.Parallel()
+	resolvers := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	answers := map[string][]string{"10.0.0.1:53": {"1.1.1.1"}, "10.0.0.2:53": {"1.1.1.1"}, "10.0.0.3:53": {"1.1.1.1"}}
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", resolvers: resolvers, check: checkConsensus, quorum: 2}
+	s := newDNSStream(dr, 100)
+	require.NoError(t, s.query(&consensusClientTest{answers: answers}))
+	require.InDelta(t, 1, s.verificationStatus, 0.0001)
+	require.NotZero(t, s.rtt)
+
+	require.Error(t, s.query(&consensusClientTest{answers: map[string][]string{}}))
+	assert.Zero(t, s.verificationStatus)
+	assert.Zero(t, s.rtt)
+	assert.Zero(t, s.agreeing)
+	assert.Zero(t, s.agreement)
+}
+
+func TestNewQuorum(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		quorum    *int
+		resolvers int
+		expected  int
+		wantErr   bool
+	}{
+		"Default of 2":        {nil, 2, 2, false},
+		"Default of 3":        {nil, 3, 2, false},
+		"Default of 4":        {nil, 4, 3, false},
+		"Configured":          {intPtr(1), 3, 1, false},
+		"All":                 {intPtr(3), 3, 3, false},
+		"Single resolver":     {nil, 1, 0, true},
+		"Zero":                {intPtr(0), 3, 0, true},
+		"More than resolvers": {intPtr(4), 3, 0, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			q, err := newQuorum(tt.quorum, tt.resolvers)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, q)
+		})
+	}
+}
@@ -1,121 +1,291 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
 	// DefaultTimeout is default timeout for the DNS requests.
 	DefaultTimeout time.Duration = 5 * time.Second
+
+	// ednsUDPSize is the EDNS0 buffer size advertised by queries, big
+	// enough for most signed answers without fragmenting.
+	ednsUDPSize = 1232
+
+	// systemResolverLabel is the resolver label of requests that use the
+	// resolver of the system.
+	systemResolverLabel = "system"
 )
 
+// rCode is the response code of a DNS response, extended response codes of
+// EDNS included, or one of the pseudo-codes that can only be expected.
 type rCode int
 
 const (
-	NOERROR  rCode = 0
-	NXDOMAIN rCode = 1
-	SERVFAIL rCode = 2
-	OTHER    rCode = 3
+	NOERROR  rCode = dns.RcodeSuccess
+	NXDOMAIN rCode = dns.RcodeNameError
+	SERVFAIL rCode = dns.RcodeServerFailure
+	// OTHER is expected to match any response code but NOERROR, NXDOMAIN
+	// and SERVFAIL. Response codes are 12 bits long, so it can't clash with
+	// a real one.
+	OTHER rCode = 4096
+	// NODATA is expected to match NOERROR responses without answers of the
+	// requested type.
+	NODATA rCode = 4097
 )
 
-var codeMap = map[rCode]string{
-	NOERROR:  "NOERROR",
-	NXDOMAIN: "NXDOMAIN",
-	SERVFAIL: "SERVFAIL",
-	OTHER:    "OTHER",
-}
-
-var reverseCodeMap = map[string]rCode{
-	"NOERROR":  NOERROR,
-	"NXDOMAIN": NXDOMAIN,
-	"SERVFAIL": SERVFAIL,
-	"OTHER":    OTHER,
+// pseudoCodes are the response codes that don't exist in DNS responses.
+var pseudoCodes = map[rCode]string{
+	OTHER:  "OTHER",
+	NODATA: "NODATA",
 }
 
 // String pretty formats the rCode type when we
 // want to print it.
 func (r rCode) String() string {
-	if str, ok := codeMap[r]; ok {
+	if str, ok := pseudoCodes[r]; ok {
+		return str
+	}
+	// BADSIG shares its value with BADVERS, but is only used by TSIG
+	// records and never as the response code of a response
+	if r == rCode(dns.RcodeBadVers) {
+		return "BADVERS"
+	}
+	if str, ok := dns.RcodeToString[int(r)]; ok {
 		return str
 	}
 	return "OTHER"
 }
 
 // newRCode will return a new rCode instance based on the
-// given string representation.
+// given string representation, which is any response code registered
+// with IANA or one of the pseudo-codes.
 func newRCode(rc string) (rCode, error) {
-	if code, ok := reverseCodeMap[rc]; ok {
-		return code, nil
+	rc = strings.ToUpper(rc)
+	for code, str := range pseudoCodes {
+		if str == rc {
+			return code, nil
+		}
+	}
+	if code, ok := dns.StringToRcode[rc]; ok {
+		return rCode(code), nil
+	}
+	if rc == "BADVERS" {
+		return rCode(dns.RcodeBadVers), nil
 	}
 	return OTHER, fmt.Errorf("%s is not a supported response code", rc)
 }
 
+// matches checks if the response code of a response with the given number
+// of answers is the expected one.
+func (r rCode) matches(code rCode, answers int) bool {
+	switch r {
+	case OTHER:
+		return code != NOERROR && code != NXDOMAIN && code != SERVFAIL
+	case NODATA:
+		return code == NOERROR && answers == 0
+	}
+	return r == code
+}
+
+// checkType defines what a request verifies.
+type checkType string
+
+const (
+	// checkAnswer verifies the response code and the answers of the response.
+	checkAnswer checkType = "answer"
+	// checkRRSIGExpiry verifies that the RRSIG records of the answer don't
+	// expire soon, on top of the answer checks.
+	checkRRSIGExpiry checkType = "rrsig-expiry"
+	// checkConsensus sends the question to every resolver of the request and
+	// verifies that enough of them agree with each other, on top of the
+	// answer checks.
+	checkConsensus checkType = "consensus"
+)
+
+// newCheckType returns the check type of the given name, falling back to
+// checkAnswer when the name is empty.
+func newCheckType(name string) (checkType, error) {
+	c := checkType(strings.ToLower(name))
+	switch c {
+	case "":
+		return checkAnswer, nil
+	case checkAnswer, checkRRSIGExpiry, checkConsensus:
+		return c, nil
+	}
+	return "", fmt.Errorf("%s is not a supported check type", name)
+}
+
 type dnsResponse struct {
-	rawResponse *dns.Msg
-	code        rCode
-	answers     []string
+	rawResponse    *dns.Msg
+	code           rCode
+	answers        []string
+	ttls           []uint32
+	extendedErrors []extendedError
 }
 
 type dnsRequest struct {
+	// index is the position of the request in the requests of the config.
+	index                int
 	domain               string
 	queryType            string
 	resolver             *string
+	resolvers            []string
+	resolverAddrs        map[string]*resolverAddr
+	useSearchList        bool
+	netResolverMode      netResolverMode
+	timeout              time.Duration
+	retries              int
+	retryBackoff         time.Duration
 	expectedResponse     []string
 	expectedResponseCode *rCode
+	match                *answerMatch
+	transport            transport
+	tlsConfig            *tls.Config
+	httpMethod           string
+	dnssec               bool
+	dnssecValidation     dnssecMode
+	trustAnchors         []*dns.DS
+	check                checkType
+	rrsigExpiryWarning   time.Duration
+	quorum               int
+	labels               map[string]string
+	// minTTL and maxTTL are the range the TTL of every answer should fall
+	// in.
+	minTTL *int
+	maxTTL *int
+	// expectedExtendedError is the info code of an Extended DNS Error the
+	// response needs to have.
+	expectedExtendedError *uint16
+	// forbiddenExtendedErrors are the info codes of Extended DNS Errors the
+	// response can't have.
+	forbiddenExtendedErrors []uint16
+	// flags are the values the flags of the header of the response need
+	// to have.
+	flags headerFlags
+	// expectedAuthority and expectedAdditional are records the authority
+	// and additional sections of the response need to have.
+	expectedAuthority  []sectionRecord
+	expectedAdditional []sectionRecord
 }
 
-type dnsStream struct {
-	request            dnsRequest
-	response           dnsResponse
-	interval           int
-	rtt                time.Duration
-	verificationStatus float64
-}
-
-func newDNSStream(r *dnsRequest, interval int) *dnsStream {
-	return &dnsStream{request: *r, rtt: 0, verificationStatus: 0, interval: interval}
-}
-
-type dnsClientInterface interface {
-	query(*dns.Msg, string) (*dns.Msg, time.Duration, error)
+// queryTimeout returns how long to wait for the response to a query.
+func (r *dnsRequest) queryTimeout() time.Duration {
+	if r.timeout > 0 {
+		return r.timeout
+	}
+	return DefaultTimeout
 }
 
-type dnsClient struct {
-	client *dns.Client
+// qtype returns the numeric DNS type of the request's query type. Query types
+// are validated when the config is loaded, so unknown types map to TypeNone.
+func (r *dnsRequest) qtype() uint16 {
+	return dns.StringToType[strings.ToUpper(r.queryType)]
 }
 
-func newDNSClient() *dnsClient {
-	c := &dns.Client{Net: "udp", ReadTimeout: DefaultTimeout}
-	return &dnsClient{client: c}
+type dnsStream struct {
+	request  dnsRequest
+	response dnsResponse
+	interval int
+	// jitter is the most the first check of the stream is randomly delayed.
+	jitter   time.Duration
+	rtt      time.Duration
+	exchange exchangeStats
+	// server is the address of the server that answered the last query.
+	server string
+	// systemResolver overrides the resolver of /etc/resolv.conf, for
+	// requests without a resolver.
+	systemResolver *systemResolver
+	// attempts is how many times the last query was sent, including retries.
+	attempts int
+	// searchQueries are the queries made while expanding the domain with
+	// the search list, in the last run.
+	searchQueries      []searchQuery
+	dnssecStatus       float64
+	rrsigExpiry        map[uint16]time.Duration
+	agreeing           int
+	agreement          float64
+	verificationStatus float64
+	// failures are every reason the verification of the last response
+	// failed for.
+	failures []string
+	// ttlSamples are the TTLs of the answers of the last response, per
+	// server and answer, to follow their countdown across queries.
+	ttlSamples map[string]ttlSample
+	// ttlAnomalies are how the TTLs of the answers of the last response
+	// failed to count down since the previous one.
+	ttlAnomalies []ttlAnomaly
 }
 
-func (d *dnsClient) query(query *dns.Msg, resolver string) (*dns.Msg, time.Duration, error) {
-	return d.client.Exchange(query, resolver)
+func newDNSStream(r *dnsRequest, interval int) *dnsStream {
+	return &dnsStream{request: *r, rtt: 0, verificationStatus: 0, interval: interval}
 }
 
 // query holds the high level logic of constructing requery, executing it
 // and parsing and verifying its results. This is the fuction that
 // watchdog worker will call to monitor a specific domain.
 func (d *dnsStream) query(dnsClient dnsClientInterface) error {
-	server, err := d.constructResolver()
+	// A failed query leaves no response behind
+	d.response = dnsResponse{}
+	d.ttlAnomalies = nil
+	if d.request.check == checkConsensus {
+		return d.queryConsensus(dnsClient)
+	}
+
+	conf, err := d.constructResolvers()
 	if err != nil {
+		d.clearRun()
 		return errors.Wrapf(err, "Cannot proceed with query to: %s", d.request.domain)
 	}
 
 	query := d.constructQuery()
-	response, rtt, err := dnsClient.query(query, server)
+	var server string
+	var response *dns.Msg
+	var rtt time.Duration
+	d.attempts, err = d.retry(func() error {
+		var err error
+		if d.request.useSearchList {
+			server, response, rtt, err = d.search(dnsClient, conf)
+		} else {
+			server, response, rtt, err = d.exchangeWithFailover(dnsClient, query, conf)
+		}
+		if err != nil && d.request.resolver != nil {
+			d.request.refreshResolver(*d.request.resolver)
+		}
+		return err
+	})
+	if reporter, ok := dnsClient.(exchangeReporter); ok {
+		d.exchange = reporter.lastExchange()
+	}
+	d.server = server
 	if err != nil {
+		d.clearRun()
 		return errors.Wrapf(err, "DNS request for: %s failed", d.request.domain)
 	}
 
 	d.rtt = rtt
 	d.response.rawResponse = response
 	d.parseResponse()
+	if d.request.transport != transportSystem {
+		d.trackTTLCountdown(time.Now())
+	}
+
+	if d.request.dnssecValidation != "" {
+		d.validateDNSSEC(dnsClient, server)
+	}
+	if d.request.check == checkRRSIGExpiry {
+		d.parseRRSIGExpiry(time.Now())
+	}
 
 	verification := d.isResponseLegit()
 	if verification {
@@ -127,20 +297,122 @@ func (d *dnsStream) query(dnsClient dnsClientInterface) error {
 	return nil
 }
 
-// constructResolver returns the resolver our DNS query will contact
+// clearRun clears what the previous run of the stream found, so a run
+// that got no response doesn't export it again as its own.
+func (d *dnsStream) clearRun() {
+	d.rtt = 0
+	d.verificationStatus = 0
+	d.dnssecStatus = 0
+	d.rrsigExpiry = nil
+	d.agreeing = 0
+	d.agreement = 0
+}
+
+// constructResolvers returns the resolvers our DNS query will contact
 // to make the request. If user hasn't specified a custom one we fall to the
-// first one that is in the resolv.conf of the system.
-func (d *dnsStream) constructResolver() (string, error) {
-	if d.request.resolver != nil {
-		return *d.request.resolver + ":53", nil
+// servers that are in the resolv.conf of the system, along with its
+// failover options.
+func (d *dnsStream) constructResolvers() (*resolvConf, error) {
+	if d.request.transport == transportSystem {
+		// The system resolver fails over between its servers on its own
+		return &resolvConf{servers: []string{systemResolverLabel}, attempts: 1}, nil
+	}
+	if d.request.resolver == nil {
+		return d.getSystemResolver().resolvers()
 	}
 
-	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
-	if err != nil || conf == nil {
-		return "", errors.Wrap(err, "Cannot initialize the local resolver")
+	conf := &resolvConf{servers: []string{d.request.resolverAddress(*d.request.resolver)}, attempts: 1}
+	if d.request.useSearchList {
+		// The search list always comes from the system
+		system, err := d.getSystemResolver().resolvers()
+		if err != nil {
+			return nil, err
+		}
+		conf.search, conf.ndots = system.search, system.ndots
 	}
+	return conf, nil
+}
 
-	return fmt.Sprintf("%s:%s", conf.Servers[0], conf.Port), nil
+// getSystemResolver returns the system resolver of the stream.
+func (d *dnsStream) getSystemResolver() *systemResolver {
+	if d.systemResolver != nil {
+		return d.systemResolver
+	}
+	return getSystemResolver()
+}
+
+// exchangeWithFailover sends the query to each server in turn until one of
+// them answers, going through the servers as many times as the attempts
+// option allows. Like the libc resolver, it moves on to the next server when
+// a server fails with SERVFAIL, NOTIMP or REFUSED, returning the last such
+// response if none of them does better. The server that answered is
+// returned along with its response.
+func (d *dnsStream) exchangeWithFailover(dnsClient dnsClientInterface, query *dns.Msg, conf *resolvConf) (string, *dns.Msg, time.Duration, error) {
+	// A timeout set on the request takes precedence over the resolv.conf one
+	if setter, ok := dnsClient.(timeoutSetter); ok && conf.timeout > 0 && d.request.timeout == 0 {
+		setter.setTimeout(conf.timeout)
+	}
+
+	var lastServer string
+	var lastResponse *dns.Msg
+	var lastRTT time.Duration
+	var lastErr error
+	for attempt := 0; attempt < max(conf.attempts, 1); attempt++ {
+		for _, server := range conf.servers {
+			response, rtt, err := dnsClient.query(query, server)
+			if err == nil && !isServerFailure(response) {
+				return server, response, rtt, nil
+			}
+			if err != nil {
+				lastErr = err
+				log.Infof("Query for domain:<%s> and DNS query type:<%s> to server:<%s> failed: %v",
+					d.request.domain, d.request.queryType, server, err)
+			} else {
+				lastServer, lastResponse, lastRTT = server, response, rtt
+				log.Infof("Query for domain:<%s> and DNS query type:<%s> to server:<%s> returned %s",
+					d.request.domain, d.request.queryType, server, dns.RcodeToString[response.Rcode])
+			}
+		}
+	}
+
+	if lastResponse != nil {
+		return lastServer, lastResponse, lastRTT, nil
+	}
+	return "", nil, 0, lastErr
+}
+
+// isServerFailure checks if the response is one that a stub resolver
+// would try the next server for.
+func isServerFailure(response *dns.Msg) bool {
+	switch response.Rcode {
+	case dns.RcodeServerFailure, dns.RcodeNotImplemented, dns.RcodeRefused:
+		return true
+	}
+	return false
+}
+
+// resolverAddress returns the address a configured resolver is contacted at.
+func (r *dnsRequest) resolverAddress(resolver string) string {
+	if r.transport == transportHTTPS {
+		// DoH resolvers are URL templates and are used as they are.
+		return resolver
+	}
+	if addr, ok := r.resolverAddrs[resolver]; ok {
+		return addr.String()
+	}
+	return net.JoinHostPort(resolver, r.transport.defaultPort())
+}
+
+// refreshResolver resolves again a resolver configured by hostname, in case
+// it stopped answering because its IP address changed.
+func (r *dnsRequest) refreshResolver(resolver string) {
+	addr, ok := r.resolverAddrs[resolver]
+	if !ok || !addr.isHostname() {
+		return
+	}
+	if err := addr.resolve(); err != nil {
+		log.Error(err)
+	}
 }
 
 // constructQuery creates and fills dns.Msg struture with our
@@ -153,107 +425,266 @@ func (d *dnsStream) constructQuery() *dns.Msg {
 		},
 		Question: make([]dns.Question, 1),
 	}
-	var qtype uint16
-	switch d.request.queryType {
-	case "A":
-		qtype = dns.TypeA
-	case "CNAME":
-		qtype = dns.TypeCNAME
-	case "MX":
-		qtype = dns.TypeMX
-	case "NS":
-		qtype = dns.TypeNS
-	}
-	query.SetQuestion(dns.Fqdn(d.request.domain), qtype)
+	query.SetQuestion(dns.Fqdn(d.request.domain), d.request.qtype())
+	// EDNS0 lets resolvers tell why they failed with Extended DNS Errors
+	query.SetEdns0(ednsUDPSize, d.request.dnssec)
+	// Resolvers only tell if they validated the response when asked to
+	query.AuthenticatedData = d.request.flags.authenticated != nil
 	return query
 }
 
+// validateDNSSEC validates the chain of trust of the response and stores
+// the result in the DNSSEC status of the stream.
+func (d *dnsStream) validateDNSSEC(dnsClient dnsClientInterface, server string) {
+	validator := newDNSSECValidator(dnsClient, server, d.request.trustAnchors)
+	if err := validator.validate(d.response.rawResponse); err != nil {
+		log.Infof("DNSSEC validation for quering domain:<%s> and DNS query type:<%s> failed: %v",
+			d.request.domain, d.request.queryType, err)
+		d.dnssecStatus = 0
+		return
+	}
+	d.dnssecStatus = 1
+}
+
 // parseResponse holds the logic of parsing a DNS response and
 // storing different answers based on type and also the response
 // code.
 func (d *dnsStream) parseResponse() {
-	switch d.response.rawResponse.Rcode {
-	case dns.RcodeSuccess:
-		d.response.code = NOERROR
-	case dns.RcodeNameError:
-		d.response.code = NXDOMAIN
-	case dns.RcodeServerFailure:
-		d.response.code = SERVFAIL
-	default:
-		d.response.code = OTHER
-	}
+	d.response.code, d.response.answers = parseRawResponse(d.response.rawResponse, d.request.qtype())
+	d.response.ttls = answerTTLs(d.response.rawResponse, d.request.qtype())
+	d.parseExtendedErrors()
+}
+
+// parseRawResponse returns the response code of a DNS response and the
+// canonical text form of its answers of the given type.
+func parseRawResponse(rawResponse *dns.Msg, qtype uint16) (rCode, []string) {
+	code := rCode(rawResponse.Rcode)
 
 	// If we have an error then there will be no answers, so exit.
-	if d.response.code != NOERROR {
-		return
+	if code != NOERROR {
+		return code, nil
 	}
 
 	var answers []string
 
-	for _, answer := range d.response.rawResponse.Answer {
-		switch t := answer.(type) {
-		case *dns.A:
-			answers = append(answers, t.A.String())
-		case *dns.AAAA:
-			answers = append(answers, t.AAAA.String())
-		case *dns.NS:
-			answers = append(answers, t.Ns)
-		case *dns.MX:
-			answers = append(answers, t.Mx)
+	for _, answer := range rawResponse.Answer {
+		if isAnswerOf(answer, qtype) {
+			answers = append(answers, answerString(answer))
 		}
 	}
 
-	d.response.answers = answers
+	return code, answers
+}
+
+// isAnswerOf checks if a record of the answer section is of the type we
+// asked for, skipping e.g. the CNAME chain that precedes the A records of an
+// aliased domain.
+func isAnswerOf(rr dns.RR, qtype uint16) bool {
+	return qtype == dns.TypeANY || rr.Header().Rrtype == qtype
+}
+
+// answerString returns the canonical text form of a resource record that
+// expected answers are compared against. Address and name records are reduced
+// to the address or name itself, TXT records to their concatenated strings and
+// every other type to its presentation format without the record header.
+func answerString(rr dns.RR) string {
+	switch t := rr.(type) {
+	case *dns.A:
+		return t.A.String()
+	case *dns.AAAA:
+		return t.AAAA.String()
+	case *dns.NS:
+		return t.Ns
+	case *dns.MX:
+		return t.Mx
+	case *dns.CNAME:
+		return t.Target
+	case *dns.PTR:
+		return t.Ptr
+	case *dns.TXT:
+		return strings.Join(t.Txt, "")
+	default:
+		return strings.TrimPrefix(rr.String(), rr.Header().String())
+	}
 }
 
 // isResponseLegit implements the logic of checking if DNS response
 // is what user has set to be expected in terms of answers and response
-// code.
+// code. Every check runs, so every reason it fails for is recorded.
 func (d *dnsStream) isResponseLegit() bool {
+	d.failures = nil
+
 	// If we have expectations for RC check it against the expected one
 	if d.request.expectedResponseCode != nil {
-		if *d.request.expectedResponseCode != d.response.code {
-			log.Infof("Expected respond code:<%s> for quering domain:<%s> and DNS query type:<%s> is not the same as the response code:<%s>",
+		if !d.request.expectedResponseCode.matches(d.response.code, len(d.response.answers)) {
+			d.fail("Expected respond code:<%s> for quering domain:<%s> and DNS query type:<%s> is not the same as the response code:<%s>",
 				d.request.expectedResponseCode, d.request.domain, d.request.queryType, d.response.code)
-			return false
 		}
 	}
 
+	d.verifyFlags()
+	d.verifySections()
+
+	if d.request.check == checkRRSIGExpiry {
+		d.verifyRRSIGs()
+	}
+
+	if d.request.check == checkConsensus {
+		d.verifyQuorum()
+	}
+
+	if code := d.request.expectedExtendedError; code != nil && !d.hasExtendedError(*code) {
+		d.fail("Expected extended error:<%s> for quering domain:<%s> and DNS query type:<%s> but got:<%v>",
+			extendedError{code: *code}, d.request.domain, d.request.queryType, d.response.extendedErrors)
+	}
+
+	for _, code := range d.request.forbiddenExtendedErrors {
+		if d.hasExtendedError(code) {
+			d.fail("Forbidden extended error:<%s> for quering domain:<%s> and DNS query type:<%s>",
+				extendedError{code: code}, d.request.domain, d.request.queryType)
+		}
+	}
+
+	d.verifyTTLs()
+
+	if d.request.dnssecValidation == dnssecEnforce && d.dnssecStatus != 1 {
+		d.fail("DNSSEC validation is enforced and failed for quering domain:<%s> and DNS query type:<%s>",
+			d.request.domain, d.request.queryType)
+	}
+
+	match := d.request.match
+	if match == nil {
+		match = &answerMatch{mode: matchExact}
+	}
+
 	// If there are expectations for answers as well check list the two lists (expected/responded)
 	if len(d.request.expectedResponse) > 0 {
-		if !areEqual(d.request.expectedResponse, d.response.answers) {
-			log.Infof("Expected answers:%v for quering domain:<%s> and DNS query type:<%s> are not the same as the response answers:<%v>",
-				d.request.expectedResponse, d.request.domain, d.request.queryType, d.response.answers)
-			return false
+		if !match.answersMatch(d.request.expectedResponse, d.response.answers) {
+			d.fail("Expected answers:%v for quering domain:<%s> and DNS query type:<%s> do not match(%s) the response answers:<%v>",
+				d.request.expectedResponse, d.request.domain, d.request.queryType, match.mode, d.response.answers)
 		}
 	}
 
-	return true
+	if ok, expected := match.countMatches(len(d.response.answers)); !ok {
+		d.fail("Expected %s answers for quering domain:<%s> and DNS query type:<%s> but got %d",
+			expected, d.request.domain, d.request.queryType, len(d.response.answers))
+	}
+
+	return len(d.failures) == 0
 }
 
-func areEqual(a, b []string) bool {
-	if len(a) != len(b) {
-		return false
+// fail records why the verification of the response failed and logs it.
+func (d *dnsStream) fail(format string, args ...any) {
+	failure := fmt.Sprintf(format, args...)
+	d.failures = append(d.failures, failure)
+	log.Info(failure)
+}
+
+// failure returns every reason the verification of the last response
+// failed for, in the order they were checked.
+func (d *dnsStream) failure() string {
+	return strings.Join(d.failures, "; ")
+}
+
+// perResolver returns a stream per resolver of the request, so each
+// resolver is queried and verified on its own.
+func (d *dnsStream) perResolver() []*dnsStream {
+	// Consensus checks query all their resolvers at once
+	if len(d.request.resolvers) <= 1 || d.request.check == checkConsensus {
+		return []*dnsStream{d}
 	}
 
-	for _, i := range a {
-		findEqual := false
-		for _, j := range b {
-			if i == j {
-				findEqual = true
-				break
-			}
-		}
-		if !findEqual {
-			return false
-		}
+	streams := make([]*dnsStream, 0, len(d.request.resolvers))
+	for i := range d.request.resolvers {
+		r := d.request
+		r.resolver = &d.request.resolvers[i]
+		streams = append(streams, newDNSStream(&r, d.interval))
 	}
-	return true
+	return streams
+}
+
+// resolverLabel returns the resolver the stream queries, as shown in
+// metrics and logs.
+func (d *dnsStream) resolverLabel() string {
+	if d.request.check == checkConsensus {
+		return consensusResolverLabel(d.request.resolvers)
+	}
+	if d.request.resolver != nil {
+		return *d.request.resolver
+	}
+	return systemResolverLabel
+}
+
+// transportLabel returns the transport of the stream, as shown in metrics.
+func (d *dnsStream) transportLabel() string {
+	if d.request.transport == "" {
+		return string(transportUDP)
+	}
+	return string(d.request.transport)
+}
+
+// labels returns the labels of the metrics of the stream.
+func (d *dnsStream) labels() prometheus.Labels {
+	labels := prometheus.Labels{
+		"domain":    d.request.domain,
+		"qtype":     d.request.queryType,
+		"resolver":  d.resolverLabel(),
+		"transport": d.transportLabel(),
+	}
+	for _, name := range customLabelNames {
+		labels[name] = d.request.labels[name]
+	}
+	return labels
 }
 
 func (d *dnsStream) updateStats() {
-	increaseRequestsCounter(d.request.domain, d.request.queryType)
-	updateRTTHistogram(d.request.domain, d.request.queryType, d.rtt.Seconds())
-	updateGaugeVerificationStatus(d.request.domain, d.request.queryType, d.verificationStatus)
-	log.Debugf("Updated prometheus stats for domain:<%s> and querytype:<%s>", d.request.domain, d.request.queryType)
+	labels := d.labels()
+	increaseRequestsCounter(labels)
+	// A run that got no response has no RTT to observe
+	if d.response.rawResponse != nil {
+		updateRTTHistogram(labels, d.rtt.Seconds())
+	}
+	updateGaugeVerificationStatus(labels, d.verificationStatus)
+	if d.request.dnssecValidation != "" {
+		updateGaugeDNSSECStatus(labels, d.dnssecStatus)
+	}
+	if d.request.check == checkRRSIGExpiry {
+		updateGaugeRRSIGExpiry(labels, d.rrsigExpiry)
+	}
+	if d.request.check == checkConsensus {
+		updateGaugeResolverAgreement(labels, d.agreement)
+	}
+	addAttemptsCounter(labels, d.attempts)
+	for _, q := range d.searchQueries {
+		increaseSearchQueriesCounter(labels, q.name, q.rcode)
+		if q.rcode != searchQueryError {
+			updateSearchRTTHistogram(labels, q.name, q.rtt.Seconds())
+		}
+	}
+	if d.server != "" {
+		increaseServerResponsesCounter(labels, d.server)
+	}
+	if d.response.rawResponse != nil {
+		increaseResponsesCounter(labels, d.response.code.String())
+	}
+	for _, e := range d.response.extendedErrors {
+		increaseExtendedErrorsCounter(labels, strconv.Itoa(int(e.code)))
+	}
+	// The system resolver doesn't tell the TTLs
+	if d.request.transport != transportSystem {
+		updateGaugeAnswerTTL(labels, d.response.answers, d.response.ttls)
+	}
+	for _, anomaly := range d.ttlAnomalies {
+		increaseTTLAnomaliesCounter(labels, string(anomaly))
+	}
+	if d.exchange.handshake > 0 {
+		updateTLSHandshakeHistogram(labels, d.exchange.handshake.Seconds())
+	}
+	if d.exchange.truncated {
+		increaseTruncatedFallbackCounter(labels)
+	}
+	if d.exchange.httpStatus != "" {
+		increaseHTTPResponsesCounter(labels, d.exchange.httpStatus)
+	}
+	log.Debugf("Updated prometheus stats for domain:<%s>, querytype:<%s> and resolver:<%s>", d.request.domain, d.request.queryType, d.resolverLabel())
 }
@@ -3,10 +3,13 @@ package main
 import (
 	"errors"
 	"net"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -21,6 +24,13 @@ func TestRCode_String(t *testing.T) {
 		"NXDOMAIN":      {NXDOMAIN, "NXDOMAIN"},
 		"SERVFAIL":      {SERVFAIL, "SERVFAIL"},
 		"OTHER":         {OTHER, "OTHER"},
+		"NODATA":        {NODATA, "NODATA"},
+		"REFUSED":       {rCode(dns.RcodeRefused), "REFUSED"},
+		"NOTIMP":        {rCode(dns.RcodeNotImplemented), "NOTIMP"},
+		"FORMERR":       {rCode(dns.RcodeFormatError), "FORMERR"},
+		"YXDOMAIN":      {rCode(dns.RcodeYXDomain), "YXDOMAIN"},
+		"Extended":      {rCode(dns.RcodeBadCookie), "BADCOOKIE"},
+		"BADVERS":       {rCode(dns.RcodeBadVers), "BADVERS"},
 		"Unknown rCode": {rCode(99), "OTHER"},
 	}
 	for name, tt := range tests {
@@ -42,6 +52,12 @@ func TestNewRCode_AllCases(t *testing.T) {
 		"Valid NXDOMAIN": {"NXDOMAIN", NXDOMAIN, false},
 		"Valid SERVFAIL": {"SERVFAIL", SERVFAIL, false},
 		"Valid OTHER":    {"OTHER", OTHER, false},
+		"Valid NODATA":   {"NODATA", NODATA, false},
+		"Valid REFUSED":  {"REFUSED", rCode(dns.RcodeRefused), false},
+		"Valid NOTIMP":   {"NOTIMP", rCode(dns.RcodeNotImplemented), false},
+		"Valid YXDOMAIN": {"YXDOMAIN", rCode(dns.RcodeYXDomain), false},
+		"Extended code":  {"BADVERS", rCode(dns.RcodeBadVers), false},
+		"Lowercase":      {"refused", rCode(dns.RcodeRefused), false},
 		"Invalid value":  {"INVALID", OTHER, true},
 		"Empty string":   {"", OTHER, true},
 	}
@@ -74,7 +90,7 @@ func (d *dnsClientTest) query(_ *dns.Msg, _ string) (*dns.Msg, time.Duration, er
 
 	rawResponse := new(dns.Msg)
 	rawResponse.Rcode = d.rcode
-	rawResponse.Answer = append(rawResponse.Answer, &dns.A{A: net.ParseIP("127.0.0.1"), Hdr: dns.RR_Header{Name: "thebeat.co"}})
+	rawResponse.Answer = append(rawResponse.Answer, &dns.A{A: net.ParseIP("127.0.0.1"), Hdr: dns.RR_Header{Name: "thebeat.co", Rrtype: dns.TypeA}})
 
 	return rawResponse, td, nil
 }
@@ -82,7 +98,7 @@ func (d *dnsClientTest) query(_ *dns.Msg, _ string) (*dns.Msg, time.Duration, er
 func TestQuery(t *testing.T) {
 	t.Parallel() // marks TLog as capable of running in parallel with other tests
 	rc := NOERROR
-	dr := &dnsRequest{"thebeat.co", "A", nil, []string{}, &rc}
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", expectedResponseCode: &rc}
 	s := newDNSStream(dr, 100)
 	c := dnsClientTest{dns.RcodeSuccess, false}
 	var expectedRTT time.Duration = 1000000000
@@ -97,7 +113,7 @@ func TestQuery(t *testing.T) {
 func TestQueryNoResponse(t *testing.T) {
 	t.Parallel() // marks TLog as capable of running in parallel with other tests
 	rc := NOERROR
-	dr := &dnsRequest{"thebeat.co", "A", nil, []string{}, &rc}
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", expectedResponseCode: &rc}
 	s := newDNSStream(dr, 100)
 	c := dnsClientTest{dns.RcodeSuccess, true}
 
@@ -111,7 +127,7 @@ func TestQueryNoResponse(t *testing.T) {
 func TestQueryValidationFails(t *testing.T) {
 	t.Parallel() // marks TLog as capable of running in parallel with other tests
 	rc := NOERROR
-	dr := &dnsRequest{"thebeat.co", "A", nil, []string{}, &rc}
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", expectedResponseCode: &rc}
 	s := newDNSStream(dr, 100)
 	c := dnsClientTest{dns.RcodeNameError, false}
 	var expectedRTT time.Duration = 1000000000
@@ -124,23 +140,26 @@ func TestQueryValidationFails(t *testing.T) {
 	assert.InDelta(t, 0, s.verificationStatus, 0.0001)
 }
 
-func TestConstructResolver(t *testing.T) {
+func TestConstructResolvers(t *testing.T) {
+	t.Parallel()
 	// Test case where user specifies custom resolver
 	resolver := "1.2.3.4"
-	dr := &dnsRequest{"thebeat.co", "A", &resolver, []string{}, nil}
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", resolver: &resolver}
 	d := newDNSStream(dr, 100)
-	res, err := d.constructResolver()
+	conf, err := d.constructResolvers()
 	require.NoError(t, err)
-	expectedResolver := "1.2.3.4:53"
-	assert.Equal(t, expectedResolver, res)
+	assert.Equal(t, []string{"1.2.3.4:53"}, conf.servers)
+	assert.Equal(t, 1, conf.attempts)
 
 	// Test case where user doesn't specify resolver
-	// We could do a bit more testing here and mock '/etc/resolv.conf' file
-	// but for start this seemed okay
-	dr = &dnsRequest{"thebeat.co", "A", nil, []string{}, nil}
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	require.NoError(t, os.WriteFile(path, []byte("nameserver 10.0.0.1\nnameserver 10.0.0.2\n"), 0o600))
+	dr = &dnsRequest{domain: "thebeat.co", queryType: "A"}
 	d = newDNSStream(dr, 100)
-	_, err = d.constructResolver()
+	d.systemResolver = newSystemResolver(path)
+	conf, err = d.constructResolvers()
 	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.1:53", "10.0.0.2:53"}, conf.servers)
 }
 
 func TestConstructQuery(t *testing.T) {
@@ -155,12 +174,19 @@ func TestConstructQuery(t *testing.T) {
 		{"test CNAME type", "thebeat.co", "CNAME", dns.TypeCNAME},
 		{"test MX type", "thebeat.co", "MX", dns.TypeMX},
 		{"test NS type", "thebeat.co", "NS", dns.TypeNS},
+		{"test AAAA type", "thebeat.co", "AAAA", dns.TypeAAAA},
+		{"test PTR type", "1.0.0.127.in-addr.arpa", "PTR", dns.TypePTR},
+		{"test SRV type", "_sip._tcp.thebeat.co", "SRV", dns.TypeSRV},
+		{"test TXT type", "thebeat.co", "TXT", dns.TypeTXT},
+		{"test CAA type", "thebeat.co", "CAA", dns.TypeCAA},
+		{"test HTTPS type", "thebeat.co", "HTTPS", dns.TypeHTTPS},
+		{"test lowercase type", "thebeat.co", "soa", dns.TypeSOA},
 	}
 	for _, tt := range tests {
 		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
 		t.Run(tt.testName, func(t *testing.T) {
 			t.Parallel() // marks each test case as capable of running in parallel with each other
-			dr := &dnsRequest{tt.testDomain, tt.testQtype, nil, []string{}, nil}
+			dr := &dnsRequest{domain: tt.testDomain, queryType: tt.testQtype}
 			s := newDNSStream(dr, 100)
 			dm := s.constructQuery()
 			// we need recursion
@@ -174,20 +200,20 @@ func TestConstructQuery(t *testing.T) {
 }
 
 func newTestDNSStream(domain, qtype, ip string, rcode int, expectedAnswers []string, expectedRcode *rCode) *dnsStream {
-	dr := &dnsRequest{domain, qtype, nil, expectedAnswers, expectedRcode}
+	dr := &dnsRequest{domain: domain, queryType: qtype, expectedResponse: expectedAnswers, expectedResponseCode: expectedRcode}
 	s := newDNSStream(dr, 100)
 
 	rawResponse := new(dns.Msg)
 	rawResponse.Rcode = rcode
 	switch qtype {
 	case "A":
-		rawResponse.Answer = append(rawResponse.Answer, &dns.A{A: net.ParseIP(ip), Hdr: dns.RR_Header{Name: domain}})
+		rawResponse.Answer = append(rawResponse.Answer, &dns.A{A: net.ParseIP(ip), Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeA}})
 	case "AAAA":
-		rawResponse.Answer = append(rawResponse.Answer, &dns.AAAA{AAAA: net.ParseIP(ip), Hdr: dns.RR_Header{Name: domain}})
+		rawResponse.Answer = append(rawResponse.Answer, &dns.AAAA{AAAA: net.ParseIP(ip), Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeAAAA}})
 	case "NS":
-		rawResponse.Answer = append(rawResponse.Answer, &dns.NS{Ns: ip, Hdr: dns.RR_Header{Name: domain}})
+		rawResponse.Answer = append(rawResponse.Answer, &dns.NS{Ns: ip, Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeNS}})
 	case "MX":
-		rawResponse.Answer = append(rawResponse.Answer, &dns.MX{Mx: ip, Hdr: dns.RR_Header{Name: domain}})
+		rawResponse.Answer = append(rawResponse.Answer, &dns.MX{Mx: ip, Hdr: dns.RR_Header{Name: domain, Rrtype: dns.TypeMX}})
 	}
 	s.response.rawResponse = rawResponse
 	return s
@@ -218,6 +244,49 @@ func TestParseResponseSuccessful(t *testing.T) {
 	}
 }
 
+func TestParseResponseSkipsOtherTypes(t *testing.T) {
+	t.Parallel()
+	s := newTestDNSStream("www.thebeat.co", "A", "127.0.0.1", dns.RcodeSuccess, []string{}, nil)
+	cname := &dns.CNAME{Target: "thebeat.co.", Hdr: dns.RR_Header{Name: "www.thebeat.co.", Rrtype: dns.TypeCNAME}}
+	s.response.rawResponse.Answer = append([]dns.RR{cname}, s.response.rawResponse.Answer...)
+
+	s.parseResponse()
+
+	assert.Equal(t, []string{"127.0.0.1"}, s.response.answers)
+}
+
+func TestAnswerString(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		record   string
+		expected string
+	}{
+		"A":      {"thebeat.co. 300 IN A 127.0.0.1", "127.0.0.1"},
+		"AAAA":   {"thebeat.co. 300 IN AAAA ::1", "::1"},
+		"NS":     {"thebeat.co. 300 IN NS ns-416.awsdns-52.com.", "ns-416.awsdns-52.com."},
+		"MX":     {"thebeat.co. 300 IN MX 10 mx.thebeat.co.", "mx.thebeat.co."},
+		"CNAME":  {"www.thebeat.co. 300 IN CNAME thebeat.co.", "thebeat.co."},
+		"PTR":    {"1.0.0.127.in-addr.arpa. 300 IN PTR localhost.", "localhost."},
+		"TXT":    {`thebeat.co. 300 IN TXT "v=spf1 " "-all"`, "v=spf1 -all"},
+		"SRV":    {"_sip._tcp.thebeat.co. 300 IN SRV 10 60 5060 sip.thebeat.co.", "10 60 5060 sip.thebeat.co."},
+		"SOA":    {"thebeat.co. 300 IN SOA ns.thebeat.co. admin.thebeat.co. 1 7200 900 1209600 86400", "ns.thebeat.co. admin.thebeat.co. 1 7200 900 1209600 86400"},
+		"CAA":    {`thebeat.co. 300 IN CAA 0 issue "letsencrypt.org"`, `0 issue "letsencrypt.org"`},
+		"HTTPS":  {`thebeat.co. 300 IN HTTPS 1 . alpn="h2"`, `1 . alpn="h2"`},
+		"TLSA":   {"_443._tcp.thebeat.co. 300 IN TLSA 3 1 1 0123456789abcdef", "3 1 1 0123456789abcdef"},
+		"DS":     {"thebeat.co. 300 IN DS 12345 13 2 0123456789abcdef", "12345 13 2 0123456789ABCDEF"},
+		"NAPTR":  {`thebeat.co. 300 IN NAPTR 100 10 "S" "SIP+D2U" "" _sip._udp.thebeat.co.`, `100 10 "S" "SIP+D2U" "" _sip._udp.thebeat.co.`},
+		"DNSKEY": {"thebeat.co. 300 IN DNSKEY 257 3 13 AAAA", "257 3 13 AAAA"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			rr, err := dns.NewRR(tt.record)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, answerString(rr))
+		})
+	}
+}
+
 func TestParseResponseUnSuccessful(t *testing.T) {
 	t.Parallel() // marks TLog as capable of running in parallel with other tests
 	tests := []struct {
@@ -227,7 +296,10 @@ func TestParseResponseUnSuccessful(t *testing.T) {
 	}{
 		{"test NXDOMAIN answer", dns.RcodeNameError, NXDOMAIN},
 		{"test SERVFAIL answer", dns.RcodeServerFailure, SERVFAIL},
-		{"test Other type answer", dns.RcodeFormatError, OTHER},
+		{"test FORMERR answer", dns.RcodeFormatError, rCode(dns.RcodeFormatError)},
+		{"test REFUSED answer", dns.RcodeRefused, rCode(dns.RcodeRefused)},
+		{"test NOTIMP answer", dns.RcodeNotImplemented, rCode(dns.RcodeNotImplemented)},
+		{"test extended BADCOOKIE answer", dns.RcodeBadCookie, rCode(dns.RcodeBadCookie)},
 	}
 	for _, tt := range tests {
 		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
@@ -241,6 +313,32 @@ func TestParseResponseUnSuccessful(t *testing.T) {
 	}
 }
 
+func TestRCodeMatches(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		expected rCode
+		code     rCode
+		answers  int
+		matches  bool
+	}{
+		"Same code":              {NXDOMAIN, NXDOMAIN, 0, true},
+		"Different code":         {NXDOMAIN, NOERROR, 1, false},
+		"REFUSED is not NOTIMP":  {rCode(dns.RcodeRefused), rCode(dns.RcodeNotImplemented), 0, false},
+		"NODATA":                 {NODATA, NOERROR, 0, true},
+		"NODATA with answers":    {NODATA, NOERROR, 2, false},
+		"NODATA is not NXDOMAIN": {NODATA, NXDOMAIN, 0, false},
+		"OTHER matches REFUSED":  {OTHER, rCode(dns.RcodeRefused), 0, true},
+		"OTHER is not SERVFAIL":  {OTHER, SERVFAIL, 0, false},
+		"OTHER is not NOERROR":   {OTHER, NOERROR, 0, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.matches, tt.expected.matches(tt.code, tt.answers))
+		})
+	}
+}
+
 func TestIsResponseLegit(t *testing.T) {
 	t.Parallel() // marks TLog as capable of running in parallel with other tests
 	tests := []struct {
@@ -280,26 +378,104 @@ func TestIsResponseLegit(t *testing.T) {
 	}
 }
 
-func TestAreEqual(t *testing.T) {
-	t.Parallel() // marks TLog as capable of running in parallel with other tests
-	tests := []struct {
-		name         string
-		a            []string
-		b            []string
-		testResponse bool
+func TestIsResponseLegitEveryFailure(t *testing.T) {
+	t.Parallel()
+	no, minute, day := false, 60, 86400
+	blocked := uint16(dns.ExtendedErrorCodeBlocked)
+	tests := map[string]struct {
+		stream   *dnsStream
+		setup    func(s *dnsStream)
+		expected []string
 	}{
-		{"test empty lists", []string{}, []string{}, true},
-		{"test same elements", []string{"a", "b"}, []string{"a", "b"}, true},
-		{"test same elements but our of order", []string{"b", "a"}, []string{"a", "b"}, true},
-		{"test subset elements first", []string{"a", "b", "c"}, []string{"a", "b"}, false},
-		{"test subset elements second", []string{"a", "b"}, []string{"a", "b", "c"}, false},
+		"Flag and section": {newTestHeaderStream(), func(s *dnsStream) {
+			s.request.flags.authoritative = &no
+			s.request.expectedAuthority = []sectionRecord{{rrtype: dns.TypeNS}}
+		}, []string{"AA flag", "authority record:<NS>"}},
+		"Both TTL bounds": {newTestTTLStream(0, 604800), func(s *dnsStream) {
+			s.request.minTTL, s.request.maxTTL = &minute, &day
+		}, []string{"below minTTL", "above maxTTL"}},
+		"Extended error and answers": {newTestEDEStream(), func(s *dnsStream) {
+			s.request.expectedExtendedError = &blocked
+			s.request.expectedResponse = []string{"127.0.0.1"}
+		}, []string{"extended error", "Expected answers"}},
 	}
-	for _, tt := range tests {
-		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
-		t.Run(tt.name, func(t *testing.T) {
-			t.Parallel() // marks each test case as capable of running in parallel with each other
-			res := areEqual(tt.a, tt.b)
-			assert.Equal(t, tt.testResponse, res)
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			tt.setup(tt.stream)
+			tt.stream.parseResponse()
+			assert.False(t, tt.stream.isResponseLegit())
+			require.Len(t, tt.stream.failures, len(tt.expected))
+			for i, reason := range tt.expected {
+				assert.Contains(t, tt.stream.failures[i], reason)
+			}
 		})
 	}
 }
+
+// TestLabelsCustom doesn't run in parallel, as it creates the metrics again
+// with custom labels.
+func TestLabelsCustom(t *testing.T) {
+	newMetrics([]string{"env", "team"})
+	defer newMetrics(nil)
+
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", labels: map[string]string{"team": "sre"}}
+	s := newDNSStream(dr, 100)
+	labels := s.labels()
+	assert.Equal(t, "sre", labels["team"])
+	assert.Contains(t, labels, "env")
+	assert.Empty(t, labels["env"])
+	assert.NotPanics(t, s.updateStats)
+}
+
+// rttSamples returns how many RTTs the histogram observed for a domain.
+func rttSamples(t *testing.T, domain string) uint64 {
+	r := prometheus.NewRegistry()
+	require.NoError(t, r.Register(dnsRTTHistogram))
+	families, err := r.Gather()
+	require.NoError(t, err)
+	var count uint64
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "domain" && l.GetValue() == domain {
+					count += m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return count
+}
+
+func TestUpdateStatsRTT(t *testing.T) {
+	t.Parallel()
+	resolver := "10.0.0.1"
+	dr := &dnsRequest{domain: "rtt.thebeat.co", queryType: "A", resolver: &resolver, expectedResponse: []string{"127.0.0.1"}}
+	s := newDNSStream(dr, 100)
+
+	require.NoError(t, s.query(&flakyClientTest{}))
+	s.updateStats()
+	assert.Equal(t, uint64(1), rttSamples(t, dr.domain))
+
+	// A run that got no response doesn't observe a zero RTT
+	require.Error(t, s.query(&flakyClientTest{failures: 1}))
+	s.updateStats()
+	assert.Equal(t, uint64(1), rttSamples(t, dr.domain))
+}
+
+func TestParseRawResponseExtendedRCode(t *testing.T) {
+	t.Parallel()
+	m := new(dns.Msg)
+	m.SetQuestion("thebeat.co.", dns.TypeA)
+	m.SetEdns0(1232, false)
+	m.Rcode = dns.RcodeBadCookie
+	wire, err := m.Pack()
+	require.NoError(t, err)
+
+	// The upper bits of extended response codes travel in the OPT record
+	unpacked := new(dns.Msg)
+	require.NoError(t, unpacked.Unpack(wire))
+	code, answers := parseRawResponse(unpacked, dns.TypeA)
+	assert.Equal(t, "BADCOOKIE", code.String())
+	assert.Empty(t, answers)
+}
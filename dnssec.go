@@ -0,0 +1,307 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// rootTrustAnchor is the DS record of the root zone KSK-2017, used as
+	// trust anchor when none is configured.
+	rootTrustAnchor = ". 86400 IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBB683457104237C7F8EC8D"
+)
+
+// dnssecMode defines what happens with the result of the DNSSEC
+// validation of a request.
+type dnssecMode string
+
+const (
+	// dnssecReport only exports the result of the validation.
+	dnssecReport dnssecMode = "report"
+	// dnssecEnforce also fails the verification of the request when the
+	// validation fails.
+	dnssecEnforce dnssecMode = "enforce"
+)
+
+// newDNSSECMode returns the DNSSEC validation mode of the given name. An
+// empty name means that no validation takes place.
+func newDNSSECMode(name string) (dnssecMode, error) {
+	m := dnssecMode(strings.ToLower(name))
+	switch m {
+	case "", dnssecReport, dnssecEnforce:
+		return m, nil
+	}
+	return "", errors.Errorf("%s is not a supported DNSSEC validation mode, use report or enforce", name)
+}
+
+// newTrustAnchors parses the DS records that DNSSEC chains of trust are
+// validated against, falling back to the root zone KSK.
+func newTrustAnchors(records []string) ([]*dns.DS, error) {
+	if len(records) == 0 {
+		records = []string{rootTrustAnchor}
+	}
+
+	anchors := make([]*dns.DS, 0, len(records))
+	for _, r := range records {
+		rr, err := dns.NewRR(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s is not a valid trust anchor", r)
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			return nil, errors.Errorf("%s is not a valid trust anchor, a DS record is required", r)
+		}
+		anchors = append(anchors, ds)
+	}
+
+	return anchors, nil
+}
+
+// dnssecValidator validates the chain of trust of a DNS response, from the
+// RRSIG of the answer RRsets to the DNSKEY of their zone and from there through
+// the DS records of the parent zones up to a trust anchor. The DNSKEY and DS
+// records are looked up using the same client and resolver as the request.
+type dnssecValidator struct {
+	client  dnsClientInterface
+	server  string
+	anchors []*dns.DS
+	now     time.Time
+	// keys caches the validated keys of every zone seen during a validation.
+	keys map[string][]*dns.DNSKEY
+	// pending holds the zones whose keys are being validated, to detect
+	// chains that loop instead of leading to a parent zone.
+	pending map[string]bool
+}
+
+func newDNSSECValidator(client dnsClientInterface, server string, anchors []*dns.DS) *dnssecValidator {
+	return &dnssecValidator{
+		client:  client,
+		server:  server,
+		anchors: anchors,
+		now:     time.Now(),
+		keys:    map[string][]*dns.DNSKEY{},
+		pending: map[string]bool{},
+	}
+}
+
+// validate checks the signatures of every RRset in the answer section of
+// the response, or in the authority section for negative answers. Proving
+// the non-existence of a name through NSEC/NSEC3 records is not supported,
+// but their signatures are validated like those of any other RRset.
+func (v *dnssecValidator) validate(response *dns.Msg) error {
+	section := response.Answer
+	if len(section) == 0 {
+		section = response.Ns
+	}
+	rrsets, sigs := splitRRsets(section)
+	if len(rrsets) == 0 {
+		return errors.New("response has no records to validate")
+	}
+
+	for _, rrset := range rrsets {
+		if err := v.validateRRset(rrset, sigs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateRRset checks that the RRset is signed by a validated key of
+// the zone that signed it.
+func (v *dnssecValidator) validateRRset(rrset []dns.RR, sigs []*dns.RRSIG) error {
+	h := rrset[0].Header()
+	name, rrtype := dns.CanonicalName(h.Name), dns.TypeToString[h.Rrtype]
+
+	var lastErr error
+	for _, sig := range coveringSigs(rrset, sigs) {
+		keys, err := v.zoneKeys(dns.CanonicalName(sig.SignerName))
+		if err != nil {
+			return err
+		}
+		if lastErr = v.verify(sig, keys, rrset); lastErr == nil {
+			return nil
+		}
+	}
+	if lastErr == nil {
+		return errors.Errorf("%s %s RRset is not signed", name, rrtype)
+	}
+	return errors.Wrapf(lastErr, "%s %s RRset signature is not valid", name, rrtype)
+}
+
+// verify checks the RRSIG of the RRset against the key that created it.
+func (v *dnssecValidator) verify(sig *dns.RRSIG, keys []*dns.DNSKEY, rrset []dns.RR) error {
+	if !sig.ValidityPeriod(v.now) {
+		return errors.Errorf("signature of key %d is not valid between %s and %s", sig.KeyTag,
+			dns.TimeToString(sig.Inception), dns.TimeToString(sig.Expiration))
+	}
+	for _, key := range keys {
+		if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+			continue
+		}
+		if err := sig.Verify(key, rrset); err == nil {
+			return nil
+		}
+	}
+	return errors.Errorf("no key with tag %d verifies the signature", sig.KeyTag)
+}
+
+// zoneKeys returns the DNSKEY RRset of the zone, once it has been checked
+// that it's signed by a key that matches either a trust anchor or the DS
+// records of the zone, which in turn are validated with the keys of the parent.
+func (v *dnssecValidator) zoneKeys(zone string) ([]*dns.DNSKEY, error) {
+	if keys, ok := v.keys[zone]; ok {
+		return keys, nil
+	}
+	if v.pending[zone] {
+		return nil, errors.Errorf("DNSSEC chain of trust of zone %s loops", zone)
+	}
+	v.pending[zone] = true
+	defer delete(v.pending, zone)
+
+	records, err := v.lookup(zone, dns.TypeDNSKEY)
+	if err != nil {
+		return nil, err
+	}
+	rrsets, sigs := splitRRsets(records)
+	if len(rrsets) == 0 {
+		return nil, errors.Errorf("no DNSKEY records found for zone %s", zone)
+	}
+	keySet := rrsets[0]
+	keys := make([]*dns.DNSKEY, 0, len(keySet))
+	for _, rr := range keySet {
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			keys = append(keys, key)
+		}
+	}
+
+	dsSet := v.anchorsOf(zone)
+	if len(dsSet) == 0 {
+		if dsSet, err = v.delegationSigners(zone); err != nil {
+			return nil, err
+		}
+	}
+
+	// The DNSKEY RRset has to be signed by one of the keys the DS records point to
+	for _, sig := range coveringSigs(keySet, sigs) {
+		var trusted []*dns.DNSKEY
+		for _, key := range keys {
+			if matchesDS(key, dsSet) {
+				trusted = append(trusted, key)
+			}
+		}
+		if v.verify(sig, trusted, keySet) == nil {
+			v.keys[zone] = keys
+			return keys, nil
+		}
+	}
+
+	return nil, errors.Errorf("DNSKEY RRset of zone %s is not signed by a key matching its DS records", zone)
+}
+
+// delegationSigners returns the validated DS records of the zone.
+func (v *dnssecValidator) delegationSigners(zone string) ([]*dns.DS, error) {
+	if zone == "." {
+		return nil, errors.New("no trust anchor found for the root zone")
+	}
+
+	records, err := v.lookup(zone, dns.TypeDS)
+	if err != nil {
+		return nil, err
+	}
+	rrsets, sigs := splitRRsets(records)
+	if len(rrsets) == 0 {
+		return nil, errors.Errorf("no DS records found for zone %s, the delegation is insecure", zone)
+	}
+	if err := v.validateRRset(rrsets[0], sigs); err != nil {
+		return nil, err
+	}
+
+	dsSet := make([]*dns.DS, 0, len(rrsets[0]))
+	for _, rr := range rrsets[0] {
+		if ds, ok := rr.(*dns.DS); ok {
+			dsSet = append(dsSet, ds)
+		}
+	}
+	return dsSet, nil
+}
+
+// anchorsOf returns the trust anchors configured for the zone.
+func (v *dnssecValidator) anchorsOf(zone string) []*dns.DS {
+	var anchors []*dns.DS
+	for _, a := range v.anchors {
+		if dns.CanonicalName(a.Hdr.Name) == zone {
+			anchors = append(anchors, a)
+		}
+	}
+	return anchors
+}
+
+// lookup sends a query with the DO bit set for the records of the given
+// name and type. Checking is disabled so the resolver returns the records
+// even if it considers them bogus, leaving the validation to us.
+func (v *dnssecValidator) lookup(name string, qtype uint16) ([]dns.RR, error) {
+	query := new(dns.Msg)
+	query.SetQuestion(name, qtype)
+	query.CheckingDisabled = true
+	query.SetEdns0(ednsUDPSize, true)
+
+	response, _, err := v.client.query(query, v.server)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DNSSEC lookup of %s %s failed", name, dns.TypeToString[qtype])
+	}
+	if response.Rcode != dns.RcodeSuccess {
+		return nil, errors.Errorf("DNSSEC lookup of %s %s returned %s", name, dns.TypeToString[qtype], dns.RcodeToString[response.Rcode])
+	}
+	return response.Answer, nil
+}
+
+// splitRRsets groups the records by name and type, keeping the RRSIG
+// records apart.
+func splitRRsets(records []dns.RR) ([][]dns.RR, []*dns.RRSIG) {
+	var rrsets [][]dns.RR
+	var sigs []*dns.RRSIG
+	index := map[string]int{}
+	for _, rr := range records {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs = append(sigs, sig)
+			continue
+		}
+		h := rr.Header()
+		key := dns.CanonicalName(h.Name) + "/" + dns.TypeToString[h.Rrtype]
+		if i, ok := index[key]; ok {
+			rrsets[i] = append(rrsets[i], rr)
+			continue
+		}
+		index[key] = len(rrsets)
+		rrsets = append(rrsets, []dns.RR{rr})
+	}
+	return rrsets, sigs
+}
+
+// coveringSigs returns the signatures that cover the RRset.
+func coveringSigs(rrset []dns.RR, sigs []*dns.RRSIG) []*dns.RRSIG {
+	h := rrset[0].Header()
+	var covering []*dns.RRSIG
+	for _, sig := range sigs {
+		if sig.TypeCovered == h.Rrtype && dns.CanonicalName(sig.Hdr.Name) == dns.CanonicalName(h.Name) {
+			covering = append(covering, sig)
+		}
+	}
+	return covering
+}
+
+// matchesDS checks if any of the DS records points to the key.
+func matchesDS(key *dns.DNSKEY, dsSet []*dns.DS) bool {
+	for _, ds := range dsSet {
+		if ds.KeyTag != key.KeyTag() || ds.Algorithm != key.Algorithm {
+			continue
+		}
+		if d := key.ToDS(ds.DigestType); d != nil && strings.EqualFold(d.Digest, ds.Digest) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testZone holds the signing key of a zone used in DNSSEC tests.
+type testZone struct {
+	key    *dns.DNSKEY
+	signer crypto.Signer
+}
+
+func newTestZone(t *testing.T, name string) *testZone {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: name, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	require.NoError(t, err)
+	signer, ok := priv.(crypto.Signer)
+	require.True(t, ok)
+	return &testZone{key: key, signer: signer}
+}
+
+// sign returns the RRSIG of the RRset, valid between the given times.
+func (z *testZone) sign(t *testing.T, rrset []dns.RR, inception, expiration time.Time) *dns.RRSIG {
+	t.Helper()
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: 3600},
+		KeyTag:     z.key.KeyTag(),
+		SignerName: z.key.Hdr.Name,
+		Algorithm:  z.key.Algorithm,
+		Inception:  uint32(inception.Unix()),  //nolint:gosec
+		Expiration: uint32(expiration.Unix()), //nolint:gosec
+	}
+	require.NoError(t, sig.Sign(z.signer, rrset))
+	return sig
+}
+
+// dnssecClientTest answers queries from a fixed set of records.
+type dnssecClientTest struct {
+	records map[string][]dns.RR
+}
+
+func recordsKey(name string, qtype uint16) string {
+	return dns.CanonicalName(name) + "/" + dns.TypeToString[qtype]
+}
+
+func (c *dnssecClientTest) query(q *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	m := new(dns.Msg)
+	m.SetReply(q)
+	rrs, ok := c.records[recordsKey(q.Question[0].Name, q.Question[0].Qtype)]
+	if !ok {
+		m.Rcode = dns.RcodeNameError
+	}
+	m.Answer = rrs
+	return m, time.Millisecond, nil
+}
+
+// testChain is a signed hierarchy of the root and the example. zone,
+// with a signed A record for www.example.
+type testChain struct {
+	root    *testZone
+	example *testZone
+	client  *dnssecClientTest
+	anchor  *dns.DS
+}
+
+func newTestChain(t *testing.T) *testChain {
+	t.Helper()
+	inception, expiration := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	c := &testChain{
+		root:    newTestZone(t, "."),
+		example: newTestZone(t, "example."),
+		client:  &dnssecClientTest{records: map[string][]dns.RR{}},
+	}
+	c.anchor = c.root.key.ToDS(dns.SHA256)
+
+	rootKeys := []dns.RR{c.root.key}
+	c.client.records[recordsKey(".", dns.TypeDNSKEY)] = append(rootKeys, c.root.sign(t, rootKeys, inception, expiration))
+
+	exampleKeys := []dns.RR{c.example.key}
+	c.client.records[recordsKey("example.", dns.TypeDNSKEY)] = append(exampleKeys, c.example.sign(t, exampleKeys, inception, expiration))
+
+	ds := []dns.RR{c.example.key.ToDS(dns.SHA256)}
+	c.client.records[recordsKey("example.", dns.TypeDS)] = append(ds, c.root.sign(t, ds, inception, expiration))
+
+	a := []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.ParseIP("127.0.0.1")}}
+	c.client.records[recordsKey("www.example.", dns.TypeA)] = append(a, c.example.sign(t, a, inception, expiration))
+
+	return c
+}
+
+func (c *testChain) response() *dns.Msg {
+	m := new(dns.Msg)
+	m.Answer = c.client.records[recordsKey("www.example.", dns.TypeA)]
+	return m
+}
+
+func TestDNSSECValidate(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		modify  func(t *testing.T, c *testChain)
+		wantErr bool
+	}{
+		"Valid chain": {func(*testing.T, *testChain) {}, false},
+		"Tampered answer": {func(_ *testing.T, c *testChain) {
+			c.client.records[recordsKey("www.example.", dns.TypeA)][0] = &dns.A{
+				Hdr: dns.RR_Header{Name: "www.example.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300}, A: net.ParseIP("127.0.0.2"),
+			}
+		}, true},
+		"Expired signature": {func(t *testing.T, c *testChain) {
+			a := c.client.records[recordsKey("www.example.", dns.TypeA)][:1]
+			c.client.records[recordsKey("www.example.", dns.TypeA)] = append(a, c.example.sign(t, a, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour)))
+		}, true},
+		"Unsigned answer": {func(_ *testing.T, c *testChain) {
+			c.client.records[recordsKey("www.example.", dns.TypeA)] = c.client.records[recordsKey("www.example.", dns.TypeA)][:1]
+		}, true},
+		"Missing DS": {func(_ *testing.T, c *testChain) {
+			delete(c.client.records, recordsKey("example.", dns.TypeDS))
+		}, true},
+		"DS of another key": {func(t *testing.T, c *testChain) {
+			ds := []dns.RR{newTestZone(t, "example.").key.ToDS(dns.SHA256)}
+			c.client.records[recordsKey("example.", dns.TypeDS)] = append(ds, c.root.sign(t, ds, time.Now().Add(-time.Hour), time.Now().Add(time.Hour)))
+		}, true},
+		"Wrong trust anchor": {func(t *testing.T, c *testChain) {
+			c.anchor = newTestZone(t, ".").key.ToDS(dns.SHA256)
+		}, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			c := newTestChain(t)
+			tt.modify(t, c)
+			err := newDNSSECValidator(c.client, "127.0.0.1:53", []*dns.DS{c.anchor}).validate(c.response())
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestDNSSECValidateTrustAnchorBelowRoot(t *testing.T) {
+	t.Parallel()
+	c := newTestChain(t)
+	// Without the root keys the chain can only be validated with an anchor for the zone itself
+	delete(c.client.records, recordsKey(".", dns.TypeDNSKEY))
+
+	err := newDNSSECValidator(c.client, "127.0.0.1:53", []*dns.DS{c.anchor}).validate(c.response())
+	require.Error(t, err)
+
+	err = newDNSSECValidator(c.client, "127.0.0.1:53", []*dns.DS{c.example.key.ToDS(dns.SHA256)}).validate(c.response())
+	require.NoError(t, err)
+}
+
+func TestQueryDNSSECValidation(t *testing.T) {
+	t.Parallel()
+	resolver := "127.0.0.1"
+	for _, mode := range []dnssecMode{dnssecReport, dnssecEnforce} {
+		c := newTestChain(t)
+		dr := &dnsRequest{domain: "www.example", queryType: "A", resolver: &resolver, dnssec: true, dnssecValidation: mode, trustAnchors: []*dns.DS{c.anchor}}
+		s := newDNSStream(dr, 100)
+		require.NoError(t, s.query(c.client))
+		assert.InDelta(t, 1, s.dnssecStatus, 0.0001)
+		assert.InDelta(t, 1, s.verificationStatus, 0.0001)
+
+		// Break the chain by dropping the signature of the answer
+		c.client.records[recordsKey("www.example.", dns.TypeA)] = c.client.records[recordsKey("www.example.", dns.TypeA)][:1]
+		require.NoError(t, s.query(c.client))
+		assert.InDelta(t, 0, s.dnssecStatus, 0.0001)
+		if mode == dnssecEnforce {
+			assert.InDelta(t, 0, s.verificationStatus, 0.0001)
+		} else {
+			assert.InDelta(t, 1, s.verificationStatus, 0.0001)
+		}
+	}
+}
+
+func TestConstructQueryDNSSEC(t *testing.T) {
+	t.Parallel()
+	s := newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A", dnssec: true}, 100)
+	opt := s.constructQuery().IsEdns0()
+	require.NotNil(t, opt)
+	assert.True(t, opt.Do())
+
+	s = newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A"}, 100)
+	opt = s.constructQuery().IsEdns0()
+	require.NotNil(t, opt)
+	assert.False(t, opt.Do())
+}
+
+func TestNewTrustAnchors(t *testing.T) {
+	t.Parallel()
+	anchors, err := newTrustAnchors(nil)
+	require.NoError(t, err)
+	require.Len(t, anchors, 1)
+	assert.Equal(t, uint16(20326), anchors[0].KeyTag)
+
+	_, err = newTrustAnchors([]string{"example. 3600 IN DNSKEY 257 3 13 AAAA"})
+	require.Error(t, err)
+
+	_, err = newTrustAnchors([]string{"not a record"})
+	require.Error(t, err)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+const (
+	// dohMediaType is the media type of DNS messages sent over HTTPS (RFC 8484).
+	dohMediaType = "application/dns-message"
+	// dohTemplateVariable is the URI template variable that DoH URL
+	// templates use for the encoded query.
+	dohTemplateVariable = "{?dns}"
+	// httpStatusTransportError is the label value used when a DoH request failed
+	// before an HTTP response was received.
+	httpStatusTransportError = "error"
+)
+
+// httpStatusError is returned when a DoH resolver answers with a
+// status other than 200 OK.
+type httpStatusError struct {
+	status int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("DoH resolver returned HTTP status %d", e.status)
+}
+
+// newDoHURL validates the URL template of a DoH resolver.
+func newDoHURL(template string) (*url.URL, error) {
+	u, err := url.Parse(strings.Replace(template, dohTemplateVariable, "", 1))
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is not a valid DoH URL", template)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, errors.Errorf("%s is not a valid DoH URL, an https:// URL is required", template)
+	}
+	return u, nil
+}
+
+// newHTTPMethod validates the HTTP method used for DoH queries, falling
+// back to GET when the method is empty.
+func newHTTPMethod(method string) (string, error) {
+	switch m := strings.ToUpper(method); m {
+	case "":
+		return http.MethodGet, nil
+	case http.MethodGet, http.MethodPost:
+		return m, nil
+	}
+	return "", errors.Errorf("%s is not a supported DoH method, use GET or POST", method)
+}
+
+// dohClient sends DNS queries to DNS-over-HTTPS (RFC 8484) resolvers.
+type dohClient struct {
+	client *http.Client
+	method string
+	stats  exchangeStats
+}
+
+func newDoHClient(r *dnsRequest) *dohClient {
+	tlsConfig := r.tlsConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	method := r.httpMethod
+	if method == "" {
+		method = http.MethodGet
+	}
+	return &dohClient{
+		client: &http.Client{
+			Timeout: r.queryTimeout(),
+			Transport: &http.Transport{
+				TLSClientConfig:   tlsConfig,
+				ForceAttemptHTTP2: true,
+			},
+		},
+		method: method,
+	}
+}
+
+// query sends the query to the DoH resolver at the given URL template. The
+// HTTP status of the exchange is kept in the client stats, so HTTP failures
+// can be told apart from DNS response codes.
+func (d *dohClient) query(query *dns.Msg, urlTemplate string) (*dns.Msg, time.Duration, error) {
+	d.stats = exchangeStats{httpStatus: httpStatusTransportError}
+
+	// RFC 8484 recommends an ID of 0 so responses are cache friendly.
+	q := query.Copy()
+	q.Id = 0
+	packed, err := q.Pack()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Cannot pack DNS query")
+	}
+
+	var handshakeStart time.Time
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			d.stats.handshake = time.Since(handshakeStart)
+		},
+	}
+	ctx, cancel := context.WithTimeout(httptrace.WithClientTrace(context.Background(), trace), d.client.Timeout)
+	defer cancel()
+	req, err := d.newRequest(ctx, urlTemplate, packed)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "DoH request to %s failed", req.URL.Host)
+	}
+	defer resp.Body.Close()
+
+	d.stats.httpStatus = strconv.Itoa(resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, &httpStatusError{status: resp.StatusCode}
+	}
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct != dohMediaType {
+		return nil, 0, errors.Errorf("DoH resolver returned unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "Cannot read DoH response")
+	}
+	rtt := time.Since(start) - d.stats.handshake
+
+	response := new(dns.Msg)
+	if err := response.Unpack(body); err != nil {
+		return nil, 0, errors.Wrap(err, "Cannot unpack DoH response")
+	}
+	response.Id = query.Id
+
+	return response, rtt, nil
+}
+
+// newRequest builds the HTTP request for the packed query. GET requests
+// carry the query base64url encoded in the dns parameter, while POST
+// requests carry it as the body.
+func (d *dohClient) newRequest(ctx context.Context, urlTemplate string, packed []byte) (*http.Request, error) {
+	u, err := newDoHURL(urlTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if d.method == http.MethodGet {
+		params := u.Query()
+		params.Set("dns", base64.RawURLEncoding.EncodeToString(packed))
+		u.RawQuery = params.Encode()
+	} else {
+		body = bytes.NewReader(packed)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, d.method, u.String(), body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot create DoH request")
+	}
+	req.Header.Set("Accept", dohMediaType)
+	if d.method == http.MethodPost {
+		req.Header.Set("Content-Type", dohMediaType)
+	}
+
+	return req, nil
+}
+
+func (d *dohClient) lastExchange() exchangeStats {
+	return d.stats
+}
+
+// close closes the idle connections kept open between queries.
+func (d *dohClient) close() {
+	d.client.CloseIdleConnections()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestDoHServer starts a local DoH server that answers every A question
+// with 127.0.0.1, or with the given HTTP status if it is not 200 OK.
+func newTestDoHServer(t *testing.T, status int) (*httptest.Server, *tls.Config) {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		var packed []byte
+		var err error
+		switch r.Method {
+		case http.MethodGet:
+			packed, err = base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		case http.MethodPost:
+			if r.Header.Get("Content-Type") != dohMediaType {
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+			packed, err = io.ReadAll(r.Body)
+		}
+		query := new(dns.Msg)
+		if err != nil || query.Unpack(packed) != nil || query.Id != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		m := new(dns.Msg)
+		m.SetReply(query)
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: query.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("127.0.0.1"),
+		})
+		out, _ := m.Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(out)
+	}))
+	t.Cleanup(server.Close)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	return server, &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+}
+
+func TestDoHClient(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		method   string
+		template string
+	}{
+		"GET":               {http.MethodGet, "/dns-query"},
+		"GET with template": {http.MethodGet, "/dns-query{?dns}"},
+		"POST":              {http.MethodPost, "/dns-query"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			server, tlsConfig := newTestDoHServer(t, http.StatusOK)
+			c := newDoHClient(&dnsRequest{transport: transportHTTPS, tlsConfig: tlsConfig, httpMethod: tt.method})
+			defer c.close()
+
+			query := new(dns.Msg)
+			query.SetQuestion("thebeat.co.", dns.TypeA)
+
+			response, _, err := c.query(query, server.URL+tt.template)
+			require.NoError(t, err)
+			assert.Equal(t, query.Id, response.Id)
+			require.Len(t, response.Answer, 1)
+			assert.Equal(t, "127.0.0.1", answerString(response.Answer[0]))
+			assert.Equal(t, "200", c.lastExchange().httpStatus)
+			assert.Positive(t, c.lastExchange().handshake)
+		})
+	}
+}
+
+func TestDoHClientHTTPErrors(t *testing.T) {
+	t.Parallel()
+	server, tlsConfig := newTestDoHServer(t, http.StatusBadGateway)
+	c := newDoHClient(&dnsRequest{transport: transportHTTPS, tlsConfig: tlsConfig})
+	defer c.close()
+
+	query := new(dns.Msg)
+	query.SetQuestion("thebeat.co.", dns.TypeA)
+
+	_, _, err := c.query(query, server.URL+"/dns-query")
+	var statusErr *httpStatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusBadGateway, statusErr.status)
+	assert.Equal(t, "502", c.lastExchange().httpStatus)
+
+	// Without the server certificate there is no HTTP response at all
+	c = newDoHClient(&dnsRequest{transport: transportHTTPS})
+	defer c.close()
+	_, _, err = c.query(query, server.URL+"/dns-query")
+	require.Error(t, err)
+	assert.False(t, errors.As(err, &statusErr))
+	assert.Equal(t, httpStatusTransportError, c.lastExchange().httpStatus)
+}
+
+func TestNewDoHURL(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		template string
+		wantErr  bool
+	}{
+		"Plain URL":      {"https://dns.google/dns-query", false},
+		"URL template":   {"https://cloudflare-dns.com/dns-query{?dns}", false},
+		"Plain HTTP":     {"http://dns.google/dns-query", true},
+		"Not a URL":      {"8.8.8.8", true},
+		"Malformed URL":  {"https://[::1/dns-query", true},
+		"Missing scheme": {"dns.google/dns-query", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := newDoHURL(tt.template)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// extendedError is an Extended DNS Error (RFC 8914) of a response, which
+// tells why a resolver failed, e.g. a bogus DNSSEC chain or a blocked name.
+type extendedError struct {
+	code uint16
+	text string
+}
+
+// String formats the error with its info code, its name and its extra text.
+func (e extendedError) String() string {
+	s := strconv.Itoa(int(e.code))
+	if name, ok := dns.ExtendedErrorCodeToString[e.code]; ok {
+		s += fmt.Sprintf(" (%s)", name)
+	}
+	if e.text != "" {
+		s += ": " + e.text
+	}
+	return s
+}
+
+// extendedErrors returns the Extended DNS Errors of the OPT record of the
+// response.
+func extendedErrors(rawResponse *dns.Msg) []extendedError {
+	opt := rawResponse.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	var eds []extendedError
+	for _, o := range opt.Option {
+		if ede, ok := o.(*dns.EDNS0_EDE); ok {
+			eds = append(eds, extendedError{code: ede.InfoCode, text: ede.ExtraText})
+		}
+	}
+	return eds
+}
+
+// parseExtendedErrors stores and logs the Extended DNS Errors of the
+// response of the stream.
+func (d *dnsStream) parseExtendedErrors() {
+	d.response.extendedErrors = extendedErrors(d.response.rawResponse)
+	for _, e := range d.response.extendedErrors {
+		log.Infof("Response for quering domain:<%s> and DNS query type:<%s> has extended error:<%s>",
+			d.request.domain, d.request.queryType, e)
+	}
+}
+
+// hasExtendedError checks if the response of the stream has an Extended DNS
+// Error with the given info code.
+func (d *dnsStream) hasExtendedError(code uint16) bool {
+	for _, e := range d.response.extendedErrors {
+		if e.code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeExtendedErrorName reduces the name of an info code to lowercase
+// letters and digits, so "DNSSEC Bogus", "dnssec-bogus" and "DNSSECBogus"
+// are the same name.
+func normalizeExtendedErrorName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		}
+		return -1
+	}, name)
+}
+
+// newExtendedErrorCode parses the info code of an Extended DNS Error, given
+// by number, e.g. 6, or by name, e.g. DNSSEC Bogus.
+func newExtendedErrorCode(value string) (uint16, error) {
+	if code, err := strconv.ParseUint(value, 10, 16); err == nil {
+		return uint16(code), nil
+	}
+	name := normalizeExtendedErrorName(value)
+	for code, n := range dns.ExtendedErrorCodeToString {
+		if name != "" && normalizeExtendedErrorName(n) == name {
+			return code, nil
+		}
+	}
+	return 0, errors.Errorf("%s is not a known extended DNS error", value)
+}
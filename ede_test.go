@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestEDEStream returns a stream with a SERVFAIL response carrying the
+// given Extended DNS Errors.
+func newTestEDEStream(codes ...uint16) *dnsStream {
+	s := newTestDNSStream("thebeat.co", "A", "", dns.RcodeServerFailure, nil, nil)
+	s.response.rawResponse.Answer = nil
+	s.response.rawResponse.SetEdns0(ednsUDPSize, false)
+	opt := s.response.rawResponse.IsEdns0()
+	for _, code := range codes {
+		opt.Option = append(opt.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: "from test"})
+	}
+	return s
+}
+
+func TestExtendedErrors(t *testing.T) {
+	t.Parallel()
+	m := new(dns.Msg)
+	m.SetQuestion("thebeat.co.", dns.TypeA)
+	m.Rcode = dns.RcodeServerFailure
+	assert.Empty(t, extendedErrors(m))
+
+	m.SetEdns0(ednsUDPSize, true)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option,
+		&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeDNSBogus, ExtraText: "signature expired"},
+		&dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0102030405060708"},
+		&dns.EDNS0_EDE{InfoCode: dns.ExtendedErrorCodeNetworkError})
+	wire, err := m.Pack()
+	require.NoError(t, err)
+
+	unpacked := new(dns.Msg)
+	require.NoError(t, unpacked.Unpack(wire))
+	assert.Equal(t, []extendedError{
+		{code: dns.ExtendedErrorCodeDNSBogus, text: "signature expired"},
+		{code: dns.ExtendedErrorCodeNetworkError},
+	}, extendedErrors(unpacked))
+}
+
+func TestExtendedErrorString(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		err      extendedError
+		expected string
+	}{
+		"Known code with text":    {extendedError{code: 15, text: "blocked by policy"}, "15 (Blocked): blocked by policy"},
+		"Known code without text": {extendedError{code: 6}, "6 (DNSSEC Bogus)"},
+		"Unknown code":            {extendedError{code: 4000, text: "private"}, "4000: private"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, tt.err.String())
+		})
+	}
+}
+
+func TestNewExtendedErrorCode(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		value    string
+		expected uint16
+		wantErr  bool
+	}{
+		"Number":              {"15", 15, false},
+		"Unassigned number":   {"4000", 4000, false},
+		"Name":                {"Blocked", 15, false},
+		"Name with spaces":    {"DNSSEC Bogus", 6, false},
+		"Lowercase with dash": {"dnssec-bogus", 6, false},
+		"Name without spaces": {"StaleAnswer", 3, false},
+		"Unknown name":        {"Broken", 0, true},
+		"Out of range":        {"70000", 0, true},
+		"Empty":               {"", 0, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			code, err := newExtendedErrorCode(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, code)
+		})
+	}
+}
+
+func TestIsResponseLegitExtendedErrors(t *testing.T) {
+	t.Parallel()
+	blocked, bogus := uint16(dns.ExtendedErrorCodeBlocked), uint16(dns.ExtendedErrorCodeDNSBogus)
+	tests := map[string]struct {
+		codes     []uint16
+		expected  *uint16
+		forbidden []uint16
+		legit     bool
+	}{
+		"No assertions":             {[]uint16{blocked}, nil, nil, true},
+		"Expected error present":    {[]uint16{bogus, blocked}, &blocked, nil, true},
+		"Expected error missing":    {[]uint16{bogus}, &blocked, nil, false},
+		"Expected error, none sent": {nil, &blocked, nil, false},
+		"Forbidden error absent":    {[]uint16{blocked}, nil, []uint16{bogus}, true},
+		"Forbidden error present":   {[]uint16{blocked, bogus}, nil, []uint16{bogus}, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := newTestEDEStream(tt.codes...)
+			s.request.expectedExtendedError = tt.expected
+			s.request.forbiddenExtendedErrors = tt.forbidden
+			s.parseResponse()
+			assert.Equal(t, tt.legit, s.isResponseLegit())
+			if !tt.legit {
+				assert.Contains(t, s.failure(), "extended error")
+			}
+		})
+	}
+}
+
+func TestGetCleanRequestExtendedErrors(t *testing.T) {
+	t.Parallel()
+	blocked, bogus, unknown, system := "Blocked", "6", "Broken", "system"
+
+	r := &YamlRequest{Domain: "thebeat.co", ExpectedExtendedError: &blocked, ForbidExtendedErrors: []string{bogus, "Stale Answer"}}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	require.NotNil(t, s.request.expectedExtendedError)
+	assert.Equal(t, uint16(15), *s.request.expectedExtendedError)
+	assert.Equal(t, []uint16{6, 3}, s.request.forbiddenExtendedErrors)
+
+	r = &YamlRequest{Domain: "thebeat.co", ExpectedExtendedError: &unknown}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", ForbidExtendedErrors: []string{unknown}}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", ExpectedExtendedError: &blocked, ForbidExtendedErrors: []string{"15"}}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &system, ExpectedExtendedError: &blocked}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
+
+func TestParseYamlConfigExtendedErrors(t *testing.T) {
+	t.Parallel()
+	r, err := parseYamlConfig([]byte(`requests:
+  - domain: thebeat.co
+    expectedExtendedError: 15
+    forbidExtendedErrors: [6, Network Error]
+`), true)
+	require.NoError(t, err)
+	require.Len(t, r.Requests, 1)
+	require.NotNil(t, r.Requests[0].ExpectedExtendedError)
+	assert.Equal(t, "15", *r.Requests[0].ExpectedExtendedError)
+	assert.Equal(t, []string{"6", "Network Error"}, r.Requests[0].ForbidExtendedErrors)
+}
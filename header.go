@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// headerFlags are the values the flags of the header of the response need
+// to have, nil meaning any value.
+type headerFlags struct {
+	// authoritative is the AA bit, set by the servers authoritative for
+	// the domain.
+	authoritative *bool
+	// authenticated is the AD bit, set by resolvers that validated the
+	// response with DNSSEC.
+	authenticated *bool
+	// recursionAvailable is the RA bit, set by resolvers that recurse.
+	recursionAvailable *bool
+	// truncated is the TC bit, set when the response didn't fit in UDP.
+	truncated *bool
+}
+
+// sectionRecord is a record the authority or additional section of the
+// response needs to have, of the given type and, unless the value is empty,
+// with the given value in the text form answers are compared with.
+type sectionRecord struct {
+	rrtype uint16
+	value  string
+}
+
+// String formats the record the way it's given in the config.
+func (r sectionRecord) String() string {
+	if r.value == "" {
+		return dns.TypeToString[r.rrtype]
+	}
+	return dns.TypeToString[r.rrtype] + " " + r.value
+}
+
+// matches checks if a record is of the type of the expected record and,
+// when it has a value, has that value.
+func (r sectionRecord) matches(rr dns.RR) bool {
+	if rr.Header().Rrtype != r.rrtype {
+		return false
+	}
+	return r.value == "" || answerString(rr) == r.value
+}
+
+// newSectionRecords parses the records a section of the response needs to
+// have, each given as a type optionally followed by a value, e.g. SOA or
+// A 192.0.2.1.
+func newSectionRecords(section string, entries []string) ([]sectionRecord, error) {
+	records := make([]sectionRecord, 0, len(entries))
+	for _, entry := range entries {
+		name, value, _ := strings.Cut(strings.TrimSpace(entry), " ")
+		rrtype, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok || rrtype == dns.TypeNone {
+			return nil, errors.Errorf("%s of %s is not a supported DNS record type", name, section)
+		}
+		records = append(records, sectionRecord{rrtype: rrtype, value: strings.TrimSpace(value)})
+	}
+	return records, nil
+}
+
+// isTruncated checks if the response was truncated, either as it was
+// received or before it was repeated over TCP.
+func (d *dnsStream) isTruncated() bool {
+	return d.response.rawResponse.Truncated || d.exchange.truncated
+}
+
+// verifyFlags records a failure for every flag of the header of the
+// response that doesn't have the expected value.
+func (d *dnsStream) verifyFlags() {
+	flags := []struct {
+		name     string
+		expected *bool
+		actual   bool
+	}{
+		{"AA", d.request.flags.authoritative, d.response.rawResponse.Authoritative},
+		{"AD", d.request.flags.authenticated, d.response.rawResponse.AuthenticatedData},
+		{"RA", d.request.flags.recursionAvailable, d.response.rawResponse.RecursionAvailable},
+		{"TC", d.request.flags.truncated, d.isTruncated()},
+	}
+	for _, f := range flags {
+		if f.expected != nil && *f.expected != f.actual {
+			d.fail("Expected %s flag:<%t> for quering domain:<%s> and DNS query type:<%s> but got:<%t>",
+				f.name, *f.expected, d.request.domain, d.request.queryType, f.actual)
+		}
+	}
+}
+
+// verifySections records a failure for every expected record the
+// authority and additional sections of the response don't have.
+func (d *dnsStream) verifySections() {
+	sections := []struct {
+		name     string
+		expected []sectionRecord
+		records  []dns.RR
+	}{
+		{"authority", d.request.expectedAuthority, d.response.rawResponse.Ns},
+		{"additional", d.request.expectedAdditional, d.response.rawResponse.Extra},
+	}
+	for _, s := range sections {
+		for _, e := range s.expected {
+			if !hasSectionRecord(s.records, e) {
+				d.fail("Expected %s record:<%s> for quering domain:<%s> and DNS query type:<%s> is not in the %s section",
+					s.name, e, d.request.domain, d.request.queryType, s.name)
+			}
+		}
+	}
+}
+
+// hasSectionRecord checks if any of the records of a section matches the
+// expected record.
+func hasSectionRecord(records []dns.RR, expected sectionRecord) bool {
+	for _, rr := range records {
+		if expected.matches(rr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHeaderStream returns a stream with an NXDOMAIN response of an
+// authoritative server, with the SOA of the zone in the authority section
+// and the address of its nameserver in the additional section.
+func newTestHeaderStream() *dnsStream {
+	s := newTestDNSStream("nope.thebeat.co", "A", "", dns.RcodeNameError, nil, nil)
+	m := s.response.rawResponse
+	m.Authoritative = true
+	m.Ns = []dns.RR{&dns.SOA{
+		Hdr: dns.RR_Header{Name: "thebeat.co.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 900},
+		Ns:  "ns1.thebeat.co.", Mbox: "hostmaster.thebeat.co.", Serial: 2024010101, Refresh: 7200, Retry: 900, Expire: 1209600, Minttl: 86400,
+	}}
+	m.Extra = []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: "ns1.thebeat.co.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 3600},
+		A:   net.ParseIP("192.0.2.1"),
+	}}
+	return s
+}
+
+func TestNewSectionRecords(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		entries  []string
+		expected []sectionRecord
+		wantErr  bool
+	}{
+		"Type only":       {[]string{"SOA"}, []sectionRecord{{rrtype: dns.TypeSOA}}, false},
+		"Type and value":  {[]string{"A 192.0.2.1"}, []sectionRecord{{rrtype: dns.TypeA, value: "192.0.2.1"}}, false},
+		"Lowercase type":  {[]string{"ns ns1.thebeat.co."}, []sectionRecord{{rrtype: dns.TypeNS, value: "ns1.thebeat.co."}}, false},
+		"Value of fields": {[]string{"  MX 10 mx.thebeat.co. "}, []sectionRecord{{rrtype: dns.TypeMX, value: "10 mx.thebeat.co."}}, false},
+		"Unknown type":    {[]string{"FOO bar"}, nil, true},
+		"Empty entry":     {[]string{""}, nil, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			records, err := newSectionRecords("expectedAuthority", tt.entries)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, records)
+		})
+	}
+}
+
+func TestSectionRecordString(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "SOA", sectionRecord{rrtype: dns.TypeSOA}.String())
+	assert.Equal(t, "A 192.0.2.1", sectionRecord{rrtype: dns.TypeA, value: "192.0.2.1"}.String())
+}
+
+func TestVerifyFlags(t *testing.T) {
+	t.Parallel()
+	yes, no := true, false
+	tests := map[string]struct {
+		flags     headerFlags
+		truncated bool
+		legit     bool
+	}{
+		"No expectations":            {headerFlags{}, false, true},
+		"Authoritative":              {headerFlags{authoritative: &yes}, false, true},
+		"Not authoritative":          {headerFlags{authoritative: &no}, false, false},
+		"Not authenticated":          {headerFlags{authenticated: &yes}, false, false},
+		"Recursion not available":    {headerFlags{recursionAvailable: &yes}, false, false},
+		"Recursion not expected":     {headerFlags{recursionAvailable: &no}, false, true},
+		"Not truncated":              {headerFlags{truncated: &no}, false, true},
+		"Truncated before TCP":       {headerFlags{truncated: &no}, true, false},
+		"Expected truncated":         {headerFlags{truncated: &yes}, true, true},
+		"One of several flags wrong": {headerFlags{authoritative: &yes, authenticated: &no, recursionAvailable: &yes}, false, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := newTestHeaderStream()
+			s.request.flags = tt.flags
+			s.exchange.truncated = tt.truncated
+			s.parseResponse()
+			assert.Equal(t, tt.legit, s.isResponseLegit())
+			if !tt.legit {
+				assert.Contains(t, s.failure(), "flag")
+			}
+		})
+	}
+}
+
+func TestVerifySections(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		authority  []sectionRecord
+		additional []sectionRecord
+		legit      bool
+	}{
+		"No expectations":         {nil, nil, true},
+		"SOA of negative answer":  {[]sectionRecord{{rrtype: dns.TypeSOA}}, nil, true},
+		"SOA with value":          {[]sectionRecord{{rrtype: dns.TypeSOA, value: "ns1.thebeat.co. hostmaster.thebeat.co. 2024010101 7200 900 1209600 86400"}}, nil, true},
+		"SOA with another serial": {[]sectionRecord{{rrtype: dns.TypeSOA, value: "ns1.thebeat.co. hostmaster.thebeat.co. 1 7200 900 1209600 86400"}}, nil, false},
+		"Missing NS":              {[]sectionRecord{{rrtype: dns.TypeNS}}, nil, false},
+		"Glue record":             {nil, []sectionRecord{{rrtype: dns.TypeA, value: "192.0.2.1"}}, true},
+		"Glue with another IP":    {nil, []sectionRecord{{rrtype: dns.TypeA, value: "192.0.2.2"}}, false},
+		"Glue in the authority":   {[]sectionRecord{{rrtype: dns.TypeA}}, nil, false},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := newTestHeaderStream()
+			s.request.expectedAuthority = tt.authority
+			s.request.expectedAdditional = tt.additional
+			s.parseResponse()
+			assert.Equal(t, tt.legit, s.isResponseLegit())
+			if !tt.legit {
+				assert.Contains(t, s.failure(), "section")
+			}
+		})
+	}
+}
+
+func TestConstructQueryAuthenticated(t *testing.T) {
+	t.Parallel()
+	yes := true
+	s := newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A", flags: headerFlags{authenticated: &yes}}, 100)
+	assert.True(t, s.constructQuery().AuthenticatedData)
+
+	s = newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A"}, 100)
+	assert.False(t, s.constructQuery().AuthenticatedData)
+}
+
+func TestGetCleanRequestHeader(t *testing.T) {
+	t.Parallel()
+	yes, system := true, "system"
+
+	r := &YamlRequest{Domain: "thebeat.co", Authoritative: &yes, RecursionAvailable: &yes,
+		ExpectedAuthority: []string{"SOA"}, ExpectedAdditional: []string{"A 192.0.2.1"}}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, &yes, s.request.flags.authoritative)
+	assert.Equal(t, &yes, s.request.flags.recursionAvailable)
+	assert.Nil(t, s.request.flags.authenticated)
+	assert.Equal(t, []sectionRecord{{rrtype: dns.TypeSOA}}, s.request.expectedAuthority)
+	assert.Equal(t, []sectionRecord{{rrtype: dns.TypeA, value: "192.0.2.1"}}, s.request.expectedAdditional)
+
+	r = &YamlRequest{Domain: "thebeat.co", ExpectedAdditional: []string{"GLUE"}}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &system, Authoritative: &yes}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &system, ExpectedAuthority: []string{"SOA"}}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
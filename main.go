@@ -2,7 +2,11 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"slices"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -14,7 +18,39 @@ var (
 	CommitHash = ""
 )
 
+// Exit codes of the subcommands.
+const (
+	exitOK = 0
+	// exitFailure means what the command checked is invalid or failed.
+	exitFailure = 1
+	// exitError means the command couldn't do its job.
+	exitError = 2
+	// exitUsage means the command was called with invalid arguments.
+	exitUsage = 2
+)
+
+// command is a subcommand of the tool, which returns its exit code.
+type command func(args []string, stdout, stderr io.Writer) int
+
+// commands are the subcommands of the tool, besides running the watchdog
+// when none is given.
+var commands = map[string]command{
+	"check":    runCheck,
+	"nagios":   runNagios,
+	"query":    runQuery,
+	"validate": runValidate,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		cmd, ok := commands[os.Args[1]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "error:unknown command %q, the commands are: %s\n", os.Args[1], strings.Join(slices.Sorted(maps.Keys(commands)), ", "))
+			os.Exit(exitUsage)
+		}
+		os.Exit(cmd(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	fmt.Printf("Starting DNS-verifier version:%s - commit hash:%s\n", Version, CommitHash)
 
 	cfg, err := newConfig()
@@ -25,7 +61,7 @@ func main() {
 
 	initLogging(cfg.logLevel)
 
-	app := newApp(cfg.appPort, cfg.watchdogRequests)
+	app := newApp(cfg.appPort, cfg.startOffset, cfg.watchdogRequests)
 	app.beforeListen()
 	if err := app.run(); err != nil {
 		fmt.Fprintf(os.Stderr, "error:%v\n", err)
@@ -33,6 +69,13 @@ func main() {
 	}
 }
 
+// initCommandLogging logs warnings and errors of the commands to stderr,
+// keeping their output on stdout free of logs.
+func initCommandLogging(stderr io.Writer) {
+	log.SetLevel(log.WarnLevel)
+	log.SetOutput(stderr)
+}
+
 func initLogging(logLevel string) {
 	var l log.Level
 	switch logLevel {
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// matchMode defines how the answers of a DNS response are compared
+// against the expected response of a request.
+type matchMode string
+
+const (
+	// matchExact requires the answers to be the same as the expected ones, in any order.
+	matchExact matchMode = "exact"
+	// matchSubset requires every answer to be one of the expected ones.
+	matchSubset matchMode = "subset"
+	// matchSuperset requires every expected answer to be among the answers.
+	matchSuperset matchMode = "superset"
+	// matchAnyOf requires at least one answer to be one of the expected ones.
+	matchAnyOf matchMode = "any-of"
+	// matchRegex requires every answer to match at least one of the expected regular expressions.
+	matchRegex matchMode = "regex"
+	// matchCIDR requires every answer to be an IP address inside one of the expected networks.
+	matchCIDR matchMode = "cidr"
+)
+
+// answerMatch holds the parsed matching rules of a request. Regular
+// expressions and networks are compiled once when the config is loaded
+// instead of on every query.
+type answerMatch struct {
+	mode       matchMode
+	patterns   []*regexp.Regexp
+	networks   []*net.IPNet
+	minAnswers *int
+	maxAnswers *int
+}
+
+// newAnswerMatch validates the matching rules of a request and compiles the
+// expected answers when the mode needs it. An empty mode falls back to exact
+// matching.
+func newAnswerMatch(mode string, expected []string, minAnswers, maxAnswers *int) (*answerMatch, error) {
+	m := &answerMatch{mode: matchMode(strings.ToLower(mode)), minAnswers: minAnswers, maxAnswers: maxAnswers}
+	if m.mode == "" {
+		m.mode = matchExact
+	}
+
+	switch m.mode {
+	case matchExact, matchSubset, matchSuperset, matchAnyOf:
+	case matchRegex:
+		for _, e := range expected {
+			// Patterns match whole answers, so 10\.0\.0\.1 doesn't match 110.0.0.12
+			re, err := regexp.Compile("^(?:" + e + ")$")
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s is not a valid regular expression", e)
+			}
+			m.patterns = append(m.patterns, re)
+		}
+	case matchCIDR:
+		for _, e := range expected {
+			_, network, err := net.ParseCIDR(e)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s is not a valid CIDR network", e)
+			}
+			m.networks = append(m.networks, network)
+		}
+	default:
+		return nil, errors.Errorf("%s is not a supported match mode", mode)
+	}
+
+	if minAnswers != nil && *minAnswers < 0 {
+		return nil, errors.Errorf("minAnswers cannot be negative, got %d", *minAnswers)
+	}
+	if maxAnswers != nil && *maxAnswers < 0 {
+		return nil, errors.Errorf("maxAnswers cannot be negative, got %d", *maxAnswers)
+	}
+	if minAnswers != nil && maxAnswers != nil && *minAnswers > *maxAnswers {
+		return nil, errors.Errorf("minAnswers(%d) cannot be greater than maxAnswers(%d)", *minAnswers, *maxAnswers)
+	}
+
+	return m, nil
+}
+
+// answersMatch compares the answers with the expected ones based on the
+// match mode. Apart from exact matching, an empty answer section never
+// matches.
+func (m *answerMatch) answersMatch(expected, answers []string) bool {
+	if m.mode != matchExact && len(answers) == 0 {
+		return false
+	}
+
+	switch m.mode {
+	case matchExact:
+		return areEqual(expected, answers)
+	case matchSubset:
+		return containsAll(expected, answers)
+	case matchSuperset:
+		return containsAll(answers, expected)
+	case matchAnyOf:
+		for _, a := range answers {
+			if slices.Contains(expected, a) {
+				return true
+			}
+		}
+		return false
+	case matchRegex:
+		for _, a := range answers {
+			if !m.matchesPattern(a) {
+				return false
+			}
+		}
+		return true
+	case matchCIDR:
+		for _, a := range answers {
+			if !m.inNetworks(a) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// countMatches checks the number of answers against the configured range.
+// It returns a description of the range when the count falls outside it.
+func (m *answerMatch) countMatches(count int) (bool, string) {
+	if m.minAnswers != nil && count < *m.minAnswers {
+		return false, fmt.Sprintf("at least %d", *m.minAnswers)
+	}
+	if m.maxAnswers != nil && count > *m.maxAnswers {
+		return false, fmt.Sprintf("at most %d", *m.maxAnswers)
+	}
+	return true, ""
+}
+
+func (m *answerMatch) matchesPattern(answer string) bool {
+	for _, re := range m.patterns {
+		if re.MatchString(answer) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *answerMatch) inNetworks(answer string) bool {
+	ip := net.ParseIP(answer)
+	if ip == nil {
+		return false
+	}
+	for _, n := range m.networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// areEqual checks if the two lists contain the same elements the same
+// number of times, regardless of their order.
+func areEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	counts := make(map[string]int, len(a))
+	for _, i := range a {
+		counts[i]++
+	}
+	for _, j := range b {
+		if counts[j] == 0 {
+			return false
+		}
+		counts[j]--
+	}
+	return true
+}
+
+// containsAll checks if every element of b is in a.
+func containsAll(a, b []string) bool {
+	for _, i := range b {
+		if !slices.Contains(a, i) {
+			return false
+		}
+	}
+	return true
+}
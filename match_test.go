@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAreEqual(t *testing.T) {
+	t.Parallel() // marks TLog as capable of running in parallel with other tests
+	tests := []struct {
+		name         string
+		a            []string
+		b            []string
+		testResponse bool
+	}{
+		{"test empty lists", []string{}, []string{}, true},
+		{"test same elements", []string{"a", "b"}, []string{"a", "b"}, true},
+		{"test same elements but our of order", []string{"b", "a"}, []string{"a", "b"}, true},
+		{"test subset elements first", []string{"a", "b", "c"}, []string{"a", "b"}, false},
+		{"test subset elements second", []string{"a", "b"}, []string{"a", "b", "c"}, false},
+		{"test duplicate elements", []string{"a", "a"}, []string{"a", "b"}, false},
+		{"test same duplicate elements", []string{"a", "b", "a"}, []string{"a", "a", "b"}, true},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // marks each test case as capable of running in parallel with each other
+			res := areEqual(tt.a, tt.b)
+			assert.Equal(t, tt.testResponse, res)
+		})
+	}
+}
+
+func TestNewAnswerMatch(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		mode       string
+		expected   []string
+		minAnswers *int
+		maxAnswers *int
+		wantMode   matchMode
+		wantErr    bool
+	}{
+		"Default mode":             {"", nil, nil, nil, matchExact, false},
+		"Uppercase mode":           {"SUBSET", nil, nil, nil, matchSubset, false},
+		"Valid regex":              {"regex", []string{`^ns-\d+\.awsdns`}, nil, nil, matchRegex, false},
+		"Invalid regex":            {"regex", []string{"("}, nil, nil, "", true},
+		"Valid CIDR":               {"cidr", []string{"10.0.0.0/8", "2001:db8::/32"}, nil, nil, matchCIDR, false},
+		"Invalid CIDR":             {"cidr", []string{"10.0.0.1"}, nil, nil, "", true},
+		"Unknown mode":             {"fuzzy", nil, nil, nil, "", true},
+		"Valid count range":        {"any-of", nil, intPtr(1), intPtr(2), matchAnyOf, false},
+		"Inverted count range":     {"exact", nil, intPtr(2), intPtr(1), "", true},
+		"Negative minimum answers": {"exact", nil, intPtr(-1), nil, "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			m, err := newAnswerMatch(tt.mode, tt.expected, tt.minAnswers, tt.maxAnswers)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMode, m.mode)
+		})
+	}
+}
+
+func TestAnswersMatch(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		mode         string
+		expected     []string
+		answers      []string
+		testResponse bool
+	}{
+		{"exact same answers", "exact", []string{"a", "b"}, []string{"b", "a"}, true},
+		{"exact missing answer", "exact", []string{"a", "b"}, []string{"a"}, false},
+		{"subset of pool", "subset", []string{"a", "b", "c"}, []string{"c", "a"}, true},
+		{"subset with unknown answer", "subset", []string{"a", "b", "c"}, []string{"a", "d"}, false},
+		{"subset with no answers", "subset", []string{"a", "b"}, []string{}, false},
+		{"superset of expected", "superset", []string{"a", "b"}, []string{"a", "b", "c"}, true},
+		{"superset missing expected", "superset", []string{"a", "b"}, []string{"a", "c"}, false},
+		{"any-of with one known answer", "any-of", []string{"a", "b"}, []string{"c", "b"}, true},
+		{"any-of with no known answer", "any-of", []string{"a", "b"}, []string{"c", "d"}, false},
+		{"regex all answers match", "regex", []string{`^ns-\d+\.awsdns-\d+\.(com|org)\.$`}, []string{"ns-416.awsdns-52.com.", "ns-1163.awsdns-17.org."}, true},
+		{"regex one answer does not match", "regex", []string{`^ns-\d+\.awsdns-\d+\.com\.$`}, []string{"ns-416.awsdns-52.com.", "ns-1163.awsdns-17.org."}, false},
+		{"regex any pattern matches", "regex", []string{`.*\.com\.`, `.*\.org\.`}, []string{"ns-416.awsdns-52.com.", "ns-1163.awsdns-17.org."}, true},
+		{"regex matches whole answers", "regex", []string{`10\.0\.0\.1`}, []string{"110.0.0.12"}, false},
+		{"regex alternatives match whole answers", "regex", []string{`10\.0\.0\.1|10\.0\.0\.2`}, []string{"10.0.0.2", "10.0.0.1"}, true},
+		{"regex alternative is not a substring", "regex", []string{`10\.0\.0\.1|10\.0\.0\.2`}, []string{"10.0.0.21"}, false},
+		{"cidr all answers inside", "cidr", []string{"10.0.0.0/8", "192.168.0.0/16"}, []string{"10.2.1.0", "192.168.1.1"}, true},
+		{"cidr answer outside", "cidr", []string{"10.0.0.0/8"}, []string{"10.2.1.0", "172.16.0.1"}, false},
+		{"cidr IPv6 answer inside", "cidr", []string{"2001:db8::/32"}, []string{"2001:db8::1"}, true},
+		{"cidr answer is not an IP", "cidr", []string{"10.0.0.0/8"}, []string{"ns.thebeat.co."}, false},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // marks each test case as capable of running in parallel with each other
+			m, err := newAnswerMatch(tt.mode, tt.expected, nil, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.testResponse, m.answersMatch(tt.expected, tt.answers))
+		})
+	}
+}
+
+func TestCountMatches(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		minAnswers   *int
+		maxAnswers   *int
+		count        int
+		testResponse bool
+	}{
+		{"no range", nil, nil, 0, true},
+		{"below minimum", intPtr(2), nil, 1, false},
+		{"at minimum", intPtr(2), nil, 2, true},
+		{"above maximum", nil, intPtr(3), 4, false},
+		{"at maximum", nil, intPtr(3), 3, true},
+		{"inside range", intPtr(1), intPtr(3), 2, true},
+	}
+	for _, tt := range tests {
+		tt := tt // NOTE: https://github.com/golang/go/wiki/CommonMistakes#using-goroutines-on-loop-iterator-variables
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel() // marks each test case as capable of running in parallel with each other
+			m, err := newAnswerMatch("", nil, tt.minAnswers, tt.maxAnswers)
+			require.NoError(t, err)
+			ok, _ := m.countMatches(tt.count)
+			assert.Equal(t, tt.testResponse, ok)
+		})
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
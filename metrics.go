@@ -1,17 +1,115 @@
 package main
 
 import (
+	"slices"
+	"strconv"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
 )
 
+// streamLabelNames are the labels every metric of a dnsStream carries, on
+// top of the custom labels of the requests.
+var streamLabelNames = []string{"domain", "qtype", "resolver", "transport"}
+
+// metricLabelNames are the labels some of the metrics carry on top of the
+// stream labels.
+var metricLabelNames = []string{"keytag", "status", "server", "name", "rcode", "code", "answer", "kind"}
+
+var (
+	// customLabelNames are the names of the labels set in the config, which
+	// every metric carries too. Requests without one of them export it empty.
+	customLabelNames []string
+	// metricCollectors are the metrics created by newMetrics.
+	metricCollectors []prometheus.Collector
+)
+
+// labelNames returns the stream label names, followed by the custom label
+// names and the given ones.
+func labelNames(extra ...string) []string {
+	names := make([]string, 0, len(streamLabelNames)+len(customLabelNames)+len(extra))
+	names = append(names, streamLabelNames...)
+	names = append(names, customLabelNames...)
+	return append(names, extra...)
+}
+
+// isReservedLabelName checks if a label name is already used by the
+// metrics, so it can't be a custom label.
+func isReservedLabelName(name string) bool {
+	return slices.Contains(streamLabelNames, name) || slices.Contains(metricLabelNames, name)
+}
+
+// withLabel returns a copy of the labels with one more label added.
+func withLabel(labels prometheus.Labels, name, value string) prometheus.Labels {
+	l := make(prometheus.Labels, len(labels)+1)
+	for k, v := range labels {
+		l[k] = v
+	}
+	l[name] = value
+	return l
+}
+
 var (
+	dnsVerificationStatus       *prometheus.GaugeVec
+	dnsDNSSECStatus             *prometheus.GaugeVec
+	dnsRRSIGExpiry              *prometheus.GaugeVec
+	dnsResolverAgreement        *prometheus.GaugeVec
+	dnsRequestsCounter          *prometheus.CounterVec
+	dnsAttemptsCounter          *prometheus.CounterVec
+	dnsRTTHistogram             *prometheus.HistogramVec
+	dnsTLSHandshakeHistogram    *prometheus.HistogramVec
+	dnsHTTPResponsesCounter     *prometheus.CounterVec
+	dnsResponsesCounter         *prometheus.CounterVec
+	dnsExtendedErrorsCounter    *prometheus.CounterVec
+	dnsAnswerTTL                *prometheus.GaugeVec
+	dnsTTLAnomaliesCounter      *prometheus.CounterVec
+	dnsServerResponsesCounter   *prometheus.CounterVec
+	dnsSearchQueriesCounter     *prometheus.CounterVec
+	dnsSearchRTTHistogram       *prometheus.HistogramVec
+	dnsTruncatedFallbackCounter *prometheus.CounterVec
+)
+
+func init() {
+	newMetrics(nil)
+}
+
+// newMetrics creates the metrics with the custom labels of the requests on
+// top of the stream labels. Every metric needs all its label names upfront,
+// so they are created once more after the config is loaded.
+func newMetrics(customLabels []string) {
+	customLabelNames = slices.Clone(customLabels)
+
 	dnsVerificationStatus = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "dns_verifier_verification_status",
 			Help: "Verification Status of a DNS request.",
 		},
-		[]string{"domain", "qtype"},
+		labelNames(),
+	)
+
+	dnsDNSSECStatus = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_verifier_dnssec_status",
+			Help: "DNSSEC chain of trust validation status of a DNS request.",
+		},
+		labelNames(),
+	)
+
+	dnsRRSIGExpiry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_verifier_rrsig_expiry_seconds",
+			Help: "Seconds until the RRSIG records of a DNS request expire, per key tag.",
+		},
+		labelNames("keytag"),
+	)
+
+	dnsResolverAgreement = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_verifier_resolver_agreement",
+			Help: "Ratio of the resolvers of a consensus check that agree with each other.",
+		},
+		labelNames(),
 	)
 
 	dnsRequestsCounter = prometheus.NewCounterVec(
@@ -19,7 +117,15 @@ var (
 			Name: "dns_verifier_stats_total",
 			Help: "Statistics of requests made from DNS verifier",
 		},
-		[]string{"domain", "qtype"},
+		labelNames(),
+	)
+
+	dnsAttemptsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_verifier_attempts_total",
+			Help: "Number of times queries were sent from DNS verifier, including retries",
+		},
+		labelNames(),
 	)
 
 	dnsRTTHistogram = prometheus.NewHistogramVec(
@@ -28,25 +134,195 @@ var (
 			Help:    "Histogram of response times for DNS requests made from DNS verifier",
 			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
 		},
-		[]string{"domain", "qtype"},
+		labelNames(),
 	)
-)
 
-func init() {
-	prometheus.MustRegister(dnsVerificationStatus)
-	prometheus.MustRegister(dnsRequestsCounter)
-	prometheus.MustRegister(dnsRTTHistogram)
+	dnsTLSHandshakeHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "dns_verifier_tls_handshake_s",
+			Help:    "Histogram of TLS handshake times for DNS-over-TLS connections made from DNS verifier",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		labelNames(),
+	)
+
+	dnsHTTPResponsesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_verifier_http_responses_total",
+			Help: "HTTP status codes of DNS-over-HTTPS requests made from DNS verifier, status is error when no HTTP response was received",
+		},
+		labelNames("status"),
+	)
+
+	dnsResponsesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_verifier_responses_total",
+			Help: "Number of responses DNS verifier got, per response code",
+		},
+		labelNames("rcode"),
+	)
+
+	dnsExtendedErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_verifier_extended_error_total",
+			Help: "Number of Extended DNS Errors in the responses DNS verifier got, per info code",
+		},
+		labelNames("code"),
+	)
+
+	dnsAnswerTTL = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_verifier_answer_ttl_seconds",
+			Help: "TTL of the answers of the last response of a DNS request, per answer.",
+		},
+		labelNames("answer"),
+	)
+
+	dnsTTLAnomaliesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_verifier_ttl_anomalies_total",
+			Help: "Number of answers whose TTL didn't count down since the previous query to the same server, kind is stuck when it didn't decrease and reset when it went up before expiring",
+		},
+		labelNames("kind"),
+	)
+
+	dnsServerResponsesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_verifier_server_responses_total",
+			Help: "Number of responses per server that answered the requests made from DNS verifier, after failing over between the servers of the local resolver",
+		},
+		labelNames("server"),
+	)
+
+	dnsSearchQueriesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_verifier_search_queries_total",
+			Help: "Number of queries made while expanding domains with the search list, per name tried and response code, rcode is error when no response was received",
+		},
+		labelNames("name", "rcode"),
+	)
+
+	dnsSearchRTTHistogram = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "dns_verifier_search_rtt_s",
+			Help:    "Histogram of response times of the queries made while expanding domains with the search list, per name tried",
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+		labelNames("name"),
+	)
+
+	dnsTruncatedFallbackCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_verifier_truncated_fallback_total",
+			Help: "Number of truncated UDP responses that DNS verifier repeated over TCP",
+		},
+		labelNames(),
+	)
+
+	metricCollectors = []prometheus.Collector{
+		dnsVerificationStatus,
+		dnsDNSSECStatus,
+		dnsRRSIGExpiry,
+		dnsResolverAgreement,
+		dnsRequestsCounter,
+		dnsAttemptsCounter,
+		dnsRTTHistogram,
+		dnsTLSHandshakeHistogram,
+		dnsHTTPResponsesCounter,
+		dnsResponsesCounter,
+		dnsExtendedErrorsCounter,
+		dnsAnswerTTL,
+		dnsTTLAnomaliesCounter,
+		dnsServerResponsesCounter,
+		dnsSearchQueriesCounter,
+		dnsSearchRTTHistogram,
+		dnsTruncatedFallbackCounter,
+	}
+}
+
+// registerMetrics registers the metrics to be scraped.
+func registerMetrics() {
+	for _, c := range metricCollectors {
+		prometheus.MustRegister(c)
+	}
 	log.Info("Metrics setup - scrape /metrics")
 }
 
-func increaseRequestsCounter(domain string, qtype string) {
-	dnsRequestsCounter.WithLabelValues(domain, qtype).Inc()
+func increaseRequestsCounter(labels prometheus.Labels) {
+	dnsRequestsCounter.With(labels).Inc()
+}
+
+func addAttemptsCounter(labels prometheus.Labels, attempts int) {
+	dnsAttemptsCounter.With(labels).Add(float64(attempts))
+}
+
+func updateRTTHistogram(labels prometheus.Labels, rtt float64) {
+	dnsRTTHistogram.With(labels).Observe(rtt)
+}
+
+func updateGaugeVerificationStatus(labels prometheus.Labels, status float64) {
+	dnsVerificationStatus.With(labels).Set(status)
+}
+
+func updateGaugeDNSSECStatus(labels prometheus.Labels, status float64) {
+	dnsDNSSECStatus.With(labels).Set(status)
+}
+
+// updateGaugeRRSIGExpiry replaces the RRSIG expiry gauges of the stream,
+// so key tags that are no longer used after a key rollover don't linger
+// around.
+func updateGaugeRRSIGExpiry(labels prometheus.Labels, expiry map[uint16]time.Duration) {
+	dnsRRSIGExpiry.DeletePartialMatch(labels)
+	for keyTag, left := range expiry {
+		dnsRRSIGExpiry.With(withLabel(labels, "keytag", strconv.Itoa(int(keyTag)))).Set(left.Seconds())
+	}
+}
+
+func updateGaugeResolverAgreement(labels prometheus.Labels, agreement float64) {
+	dnsResolverAgreement.With(labels).Set(agreement)
+}
+
+func updateTLSHandshakeHistogram(labels prometheus.Labels, handshake float64) {
+	dnsTLSHandshakeHistogram.With(labels).Observe(handshake)
+}
+
+func increaseHTTPResponsesCounter(labels prometheus.Labels, status string) {
+	dnsHTTPResponsesCounter.With(withLabel(labels, "status", status)).Inc()
+}
+
+func increaseResponsesCounter(labels prometheus.Labels, rcode string) {
+	dnsResponsesCounter.With(withLabel(labels, "rcode", rcode)).Inc()
+}
+
+func increaseExtendedErrorsCounter(labels prometheus.Labels, code string) {
+	dnsExtendedErrorsCounter.With(withLabel(labels, "code", code)).Inc()
+}
+
+// updateGaugeAnswerTTL replaces the TTL gauges of the stream, so answers
+// that are gone from the response don't linger around.
+func updateGaugeAnswerTTL(labels prometheus.Labels, answers []string, ttls []uint32) {
+	dnsAnswerTTL.DeletePartialMatch(labels)
+	for i, answer := range answers {
+		dnsAnswerTTL.With(withLabel(labels, "answer", answer)).Set(float64(ttls[i]))
+	}
+}
+
+func increaseTTLAnomaliesCounter(labels prometheus.Labels, kind string) {
+	dnsTTLAnomaliesCounter.With(withLabel(labels, "kind", kind)).Inc()
+}
+
+func increaseServerResponsesCounter(labels prometheus.Labels, server string) {
+	dnsServerResponsesCounter.With(withLabel(labels, "server", server)).Inc()
+}
+
+func increaseSearchQueriesCounter(labels prometheus.Labels, name, rcode string) {
+	dnsSearchQueriesCounter.With(withLabel(withLabel(labels, "name", name), "rcode", rcode)).Inc()
 }
 
-func updateRTTHistogram(domain, qtype string, rtt float64) {
-	dnsRTTHistogram.WithLabelValues(domain, qtype).Observe(rtt)
+func updateSearchRTTHistogram(labels prometheus.Labels, name string, rtt float64) {
+	dnsSearchRTTHistogram.With(withLabel(labels, "name", name)).Observe(rtt)
 }
 
-func updateGaugeVerificationStatus(domain, qtype string, status float64) {
-	dnsVerificationStatus.WithLabelValues(domain, qtype).Set(status)
+func increaseTruncatedFallbackCounter(labels prometheus.Labels) {
+	dnsTruncatedFallbackCounter.With(labels).Inc()
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// nagiosState is the state of a Nagios plugin, which is also its exit code.
+type nagiosState int
+
+const (
+	nagiosOK nagiosState = iota
+	nagiosWarning
+	nagiosCritical
+	nagiosUnknown
+)
+
+func (s nagiosState) String() string {
+	switch s {
+	case nagiosOK:
+		return "OK"
+	case nagiosWarning:
+		return "WARNING"
+	case nagiosCritical:
+		return "CRITICAL"
+	case nagiosUnknown:
+		return "UNKNOWN"
+	}
+	return "UNKNOWN"
+}
+
+// nagiosThresholds are the RTTs from which a passing check is a warning or
+// critical, zero meaning no threshold.
+type nagiosThresholds struct {
+	warning  time.Duration
+	critical time.Duration
+}
+
+// nagiosRequestFlags are the flags of the nagios command that give the
+// request to run, instead of a request of the config.
+var nagiosRequestFlags = []string{"domain", "type", "resolver", "transport", "expect", "rcode", "match", "timeout"}
+
+// runNagios runs a single check, either given by its flags or a request of
+// the config, and reports it the way Nagios and Icinga plugins do: a line
+// with the state, a message and the RTT and number of answers as perfdata,
+// and the state as exit code.
+func runNagios(args []string, stdout, stderr io.Writer) int {
+	flags := pflag.NewFlagSet("nagios", pflag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.StringP("config", "c", "", "path of the config file to run a request of, instead of the one given by the flags")
+	index := flags.IntP("request", "r", -1, "index of the request of the config to run, required when it has more than one")
+	var req YamlRequest
+	flags.StringVarP(&req.Domain, "domain", "d", "", "domain to query")
+	flags.StringVarP(&req.QueryType, "type", "t", "A", "DNS query type")
+	resolver := flags.StringP("resolver", "s", "", "resolver to query, by default the ones of /etc/resolv.conf")
+	transport := flags.String("transport", "", "protocol used to talk to the resolver, udp, tcp, tls, https or system")
+	flags.StringSliceVarP(&req.ExpectedResponse, "expect", "e", nil, "expected answer, can be repeated")
+	rcode := flags.String("rcode", "", "expected response code")
+	match := flags.String("match", "", "how the answers are compared with the expected ones")
+	timeout := flags.String("timeout", "", "how long to wait for the response")
+	warning := flags.Duration("warning", 0, "RTT from which the check is a warning")
+	critical := flags.Duration("critical", 0, "RTT from which the check is critical")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return int(nagiosOK)
+		}
+		return nagiosExit(stdout, nagiosUnknown, err.Error(), "")
+	}
+
+	if *path != "" {
+		// The request of the config replaces the one given by the flags
+		for _, name := range nagiosRequestFlags {
+			if flags.Changed(name) {
+				return nagiosExit(stdout, nagiosUnknown, fmt.Sprintf("--%s cannot be set along with --config", name), "")
+			}
+		}
+	}
+
+	initCommandLogging(stderr)
+	setFlag := func(name string, value *string) *string {
+		if flags.Changed(name) {
+			return value
+		}
+		return nil
+	}
+	req.Resolver = setFlag("resolver", resolver)
+	req.Transport = setFlag("transport", transport)
+	req.ExpectedResponseCode = setFlag("rcode", rcode)
+	req.Match = setFlag("match", match)
+	req.Timeout = setFlag("timeout", timeout)
+
+	streams, err := nagiosStreams(*path, *index, req)
+	if err != nil {
+		return nagiosExit(stdout, nagiosUnknown, err.Error(), "")
+	}
+
+	results := runChecks(streams, newResolverClient)
+	state, message, perfdata := nagiosReport(results, nagiosThresholds{warning: *warning, critical: *critical})
+	return nagiosExit(stdout, state, message, perfdata)
+}
+
+// nagiosStreams returns the streams of the request of the config at the
+// index or, without a config, of the given request.
+func nagiosStreams(path string, index int, req YamlRequest) ([]*dnsStream, error) {
+	if path != "" {
+		r, err := loadYamlConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 && len(r.Requests) == 1 {
+			index = 0
+		}
+		if index < 0 || index >= len(r.Requests) {
+			return nil, errors.Errorf("--request needs to be the index of one of the %d requests of the config", len(r.Requests))
+		}
+		req = r.Defaults.apply(r.Requests[index])
+	} else {
+		initViper()
+		if err := (&YamlRequests{}).fallbackToEnvironment(); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := req.getCleanRequest()
+	if err != nil {
+		return nil, err
+	}
+	return s.perResolver(), nil
+}
+
+// nagiosReport returns the state of the results, with the worst state of
+// them winning, along with the message and perfdata reporting them.
+func nagiosReport(results []*checkResult, thresholds nagiosThresholds) (nagiosState, string, string) {
+	state := nagiosOK
+	messages := make([]string, 0, len(results))
+	perfdata := make([]string, 0, 2*len(results))
+	for _, r := range results {
+		s, message := nagiosResult(r, thresholds)
+		state = max(state, s)
+		messages = append(messages, message)
+
+		suffix := ""
+		if len(results) > 1 {
+			suffix = "_" + r.Resolver
+		}
+		if r.Status != checkErrored {
+			perfdata = append(perfdata,
+				fmt.Sprintf("'rtt%s'=%.6fs;%s;%s;0", suffix, r.RTTSeconds, nagiosThreshold(thresholds.warning), nagiosThreshold(thresholds.critical)),
+				fmt.Sprintf("'answers%s'=%d;;;0", suffix, len(r.Answers)))
+		}
+	}
+	return state, strings.Join(messages, ", "), strings.Join(perfdata, " ")
+}
+
+// nagiosResult returns the state of a result and the message reporting it.
+func nagiosResult(r *checkResult, thresholds nagiosThresholds) (nagiosState, string) {
+	name := r.name()
+	switch r.Status {
+	case checkErrored, checkFailed:
+		return nagiosCritical, fmt.Sprintf("%s: %s", name, r.Message)
+	case checkPassed:
+	}
+
+	rtt := time.Duration(r.RTTSeconds * float64(time.Second)).Round(time.Microsecond)
+	message := fmt.Sprintf("%s: %s with %d answers in %s", name, r.ResponseCode, len(r.Answers), rtt)
+	switch {
+	case thresholds.critical > 0 && rtt >= thresholds.critical:
+		return nagiosCritical, fmt.Sprintf("%s, over %s", message, thresholds.critical)
+	case thresholds.warning > 0 && rtt >= thresholds.warning:
+		return nagiosWarning, fmt.Sprintf("%s, over %s", message, thresholds.warning)
+	}
+	return nagiosOK, message
+}
+
+// nagiosThreshold returns a threshold in the perfdata form, in seconds.
+func nagiosThreshold(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.6f", d.Seconds())
+}
+
+// nagiosExit writes the plugin output line and returns the state as exit
+// code.
+func nagiosExit(w io.Writer, state nagiosState, message, perfdata string) int {
+	// The pipe separates the perfdata from the message
+	line := fmt.Sprintf("%s - %s", state, strings.ReplaceAll(message, "|", "/"))
+	if perfdata != "" {
+		line += " | " + perfdata
+	}
+	fmt.Fprintln(w, line)
+	return int(state)
+}
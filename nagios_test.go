@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNagiosReport(t *testing.T) {
+	t.Parallel()
+	passed := &checkResult{Domain: "thebeat.co", QueryType: "A", Resolver: "10.0.0.1", Transport: "udp", Status: checkPassed, ResponseCode: "NOERROR", Answers: []string{"1.1.1.1", "2.2.2.2"}, RTTSeconds: 0.2}
+	other := &checkResult{Domain: "thebeat.co", QueryType: "A", Resolver: "10.0.0.2", Transport: "udp", Status: checkPassed, ResponseCode: "NOERROR", Answers: []string{"1.1.1.1"}, RTTSeconds: 0.01}
+	failed := &checkResult{Domain: "thebeat.co", QueryType: "A", Resolver: "10.0.0.1", Transport: "udp", Status: checkFailed, ResponseCode: "NXDOMAIN", RTTSeconds: 0.01, Message: "unexpected response code"}
+	errored := &checkResult{Domain: "thebeat.co", QueryType: "A", Resolver: "10.0.0.2", Transport: "udp", Status: checkErrored, Message: "timeout"}
+
+	tests := map[string]struct {
+		results    []*checkResult
+		thresholds nagiosThresholds
+		state      nagiosState
+		message    string
+		perfdata   string
+	}{
+		"OK": {
+			results:  []*checkResult{passed},
+			state:    nagiosOK,
+			message:  "thebeat.co A via 10.0.0.1 (udp): NOERROR with 2 answers in 200ms",
+			perfdata: "'rtt'=0.200000s;;;0 'answers'=2;;;0",
+		},
+		"Warning": {
+			results:    []*checkResult{passed},
+			thresholds: nagiosThresholds{warning: 100 * time.Millisecond, critical: time.Second},
+			state:      nagiosWarning,
+			message:    "thebeat.co A via 10.0.0.1 (udp): NOERROR with 2 answers in 200ms, over 100ms",
+			perfdata:   "'rtt'=0.200000s;0.100000;1.000000;0 'answers'=2;;;0",
+		},
+		"Critical RTT": {
+			results:    []*checkResult{passed},
+			thresholds: nagiosThresholds{warning: 100 * time.Millisecond, critical: 150 * time.Millisecond},
+			state:      nagiosCritical,
+			message:    "thebeat.co A via 10.0.0.1 (udp): NOERROR with 2 answers in 200ms, over 150ms",
+			perfdata:   "'rtt'=0.200000s;0.100000;0.150000;0 'answers'=2;;;0",
+		},
+		"Failed": {
+			results:  []*checkResult{failed},
+			state:    nagiosCritical,
+			message:  "thebeat.co A via 10.0.0.1 (udp): unexpected response code",
+			perfdata: "'rtt'=0.010000s;;;0 'answers'=0;;;0",
+		},
+		"Worst of resolvers": {
+			results:  []*checkResult{passed, errored},
+			state:    nagiosCritical,
+			message:  "thebeat.co A via 10.0.0.1 (udp): NOERROR with 2 answers in 200ms, thebeat.co A via 10.0.0.2 (udp): timeout",
+			perfdata: "'rtt_10.0.0.1'=0.200000s;;;0 'answers_10.0.0.1'=2;;;0",
+		},
+		"Every resolver passed": {
+			results:  []*checkResult{passed, other},
+			state:    nagiosOK,
+			message:  "thebeat.co A via 10.0.0.1 (udp): NOERROR with 2 answers in 200ms, thebeat.co A via 10.0.0.2 (udp): NOERROR with 1 answers in 10ms",
+			perfdata: "'rtt_10.0.0.1'=0.200000s;;;0 'answers_10.0.0.1'=2;;;0 'rtt_10.0.0.2'=0.010000s;;;0 'answers_10.0.0.2'=1;;;0",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			state, message, perfdata := nagiosReport(tt.results, tt.thresholds)
+			assert.Equal(t, tt.state, state)
+			assert.Equal(t, tt.message, message)
+			assert.Equal(t, tt.perfdata, perfdata)
+		})
+	}
+}
+
+func TestNagiosExit(t *testing.T) {
+	t.Parallel()
+	var out bytes.Buffer
+	assert.Equal(t, 1, nagiosExit(&out, nagiosWarning, "slow", "'rtt'=1s;;;0"))
+	assert.Equal(t, "WARNING - slow | 'rtt'=1s;;;0\n", out.String())
+
+	out.Reset()
+	assert.Equal(t, 3, nagiosExit(&out, nagiosUnknown, "a|b", ""))
+	assert.Equal(t, "UNKNOWN - a/b\n", out.String())
+}
+
+func TestRunNagiosConfigWithRequestFlags(t *testing.T) {
+	t.Parallel()
+	tests := map[string][]string{
+		"Domain":   {"--config", "config.yaml", "--domain", "thebeat.co"},
+		"Type":     {"-c", "config.yaml", "-t", "MX"},
+		"Resolver": {"-c", "config.yaml", "--resolver", "8.8.8.8"},
+		"Expect":   {"-c", "config.yaml", "-e", "127.0.0.1"},
+		"Timeout":  {"-c", "config.yaml", "--timeout", "1s"},
+	}
+	for name, args := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			assert.Equal(t, int(nagiosUnknown), runNagios(args, &out, io.Discard))
+			assert.Contains(t, out.String(), "UNKNOWN - --")
+			assert.Contains(t, out.String(), "cannot be set along with --config")
+		})
+	}
+}
+
+// TestNagiosStreams doesn't run in parallel, as it loads the config through
+// the global viper instance.
+func TestNagiosStreams(t *testing.T) {
+	resolver := "10.0.0.1"
+	streams, err := nagiosStreams("", -1, YamlRequest{Domain: "thebeat.co", QueryType: "NS", Resolver: &resolver})
+	require.NoError(t, err)
+	require.Len(t, streams, 1)
+	assert.Equal(t, "NS", streams[0].request.queryType)
+
+	_, err = nagiosStreams("", -1, YamlRequest{QueryType: "A"})
+	require.Error(t, err)
+
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`
+defaults:
+  resolver: 10.0.0.1
+requests:
+  - domain: thebeat.co
+  - domain: google.com
+    resolvers: [10.0.0.2, 10.0.0.3]
+`), 0o600))
+
+	streams, err = nagiosStreams(config, 1, YamlRequest{})
+	require.NoError(t, err)
+	require.Len(t, streams, 2)
+	assert.Equal(t, "google.com", streams[0].request.domain)
+
+	streams, err = nagiosStreams(config, 0, YamlRequest{})
+	require.NoError(t, err)
+	require.Len(t, streams, 1)
+	assert.Equal(t, "10.0.0.1", streams[0].resolverLabel())
+
+	_, err = nagiosStreams(config, -1, YamlRequest{})
+	require.Error(t, err)
+	_, err = nagiosStreams(config, 2, YamlRequest{})
+	require.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+// parseQueryArgs builds the request of the query command from its
+// arguments, which are the domain, optionally followed by the query type,
+// and any number of resolvers prefixed with @, in any order, like dig takes
+// them.
+func parseQueryArgs(args []string, req *YamlRequest) error {
+	var positional []string
+	for _, arg := range args {
+		if resolver, ok := strings.CutPrefix(arg, "@"); ok {
+			if resolver == "" {
+				return errors.New("@ needs to be followed by a resolver")
+			}
+			req.Resolvers = append(req.Resolvers, resolver)
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	switch len(positional) {
+	case 0:
+		return errors.New("a domain to query is required")
+	case 1:
+	case 2:
+		req.QueryType = positional[1]
+	default:
+		return errors.Errorf("expected a domain and a query type, got %s", strings.Join(positional, " "))
+	}
+	req.Domain = positional[0]
+	return nil
+}
+
+// runQuery runs a single ad-hoc query exactly the way the watchdog does and
+// prints the response, the answers it's verified with, its RTT and the
+// verdict of the verification. It exits with exitOK when every response
+// passes verification, exitFailure when any of them fails it and exitError
+// when any query gets no response.
+func runQuery(args []string, stdout, stderr io.Writer) int {
+	flags := pflag.NewFlagSet("query", pflag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: dns-verifier query <domain> [type] [@resolver...] [flags]")
+		flags.PrintDefaults()
+	}
+	var req YamlRequest
+	flags.StringSliceVarP(&req.ExpectedResponse, "expect", "e", nil, "expected answer, can be repeated")
+	rcode := flags.String("expect-rcode", "", "expected response code")
+	transport := flags.String("transport", "", "protocol used to talk to the resolver, udp, tcp, tls, https or system")
+	match := flags.String("match", "", "how the answers are compared with the expected ones")
+	timeout := flags.String("timeout", "", "how long to wait for the response")
+	dnssec := flags.Bool("dnssec", false, "set the DO bit in the query")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if err := parseQueryArgs(flags.Args(), &req); err != nil {
+		fmt.Fprintf(stderr, "error:%v\n", err)
+		flags.Usage()
+		return exitUsage
+	}
+
+	setFlag := func(name string, value *string) *string {
+		if flags.Changed(name) {
+			return value
+		}
+		return nil
+	}
+	req.ExpectedResponseCode = setFlag("expect-rcode", rcode)
+	req.Transport = setFlag("transport", transport)
+	req.Match = setFlag("match", match)
+	req.Timeout = setFlag("timeout", timeout)
+	req.DNSSEC = dnssec
+
+	initCommandLogging(stderr)
+	s, err := req.getCleanRequest()
+	if err != nil {
+		fmt.Fprintf(stderr, "error:%v\n", err)
+		return exitUsage
+	}
+
+	code := exitOK
+	for i, stream := range s.perResolver() {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		client := newResolverClient(&stream.request)
+		err := stream.query(client)
+		client.close()
+		if err := writeQueryResult(stdout, stream, err); err != nil {
+			fmt.Fprintf(stderr, "error:%v\n", err)
+			return exitError
+		}
+		switch {
+		case err != nil:
+			code = exitError
+		case stream.verificationStatus != 1 && code == exitOK:
+			code = exitFailure
+		}
+	}
+	return code
+}
+
+// writeQueryResult writes the outcome of the query of a stream the way dig
+// does, followed by the answers it was verified with and the verdict.
+func writeQueryResult(w io.Writer, s *dnsStream, err error) error {
+	var b strings.Builder
+	server := s.server
+	if server == "" {
+		server = s.resolverLabel()
+	}
+	fmt.Fprintf(&b, ";; SERVER: %s (%s)\n", server, s.transportLabel())
+	if err != nil {
+		fmt.Fprintf(&b, ";; ATTEMPTS: %d\n;; ERROR: %v\n", s.attempts, err)
+		_, err := io.WriteString(w, b.String())
+		return errors.Wrap(err, "Cannot write the query result")
+	}
+
+	if s.response.rawResponse != nil {
+		b.WriteString(s.response.rawResponse.String())
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, ";; RTT: %s\n;; ATTEMPTS: %d\n", s.rtt, s.attempts)
+	for _, q := range s.searchQueries {
+		fmt.Fprintf(&b, ";; SEARCHED: %s %s in %s\n", q.name, q.rcode, q.rtt)
+	}
+
+	fmt.Fprintf(&b, "\n;; VERIFIED ANSWERS (%s):\n", s.request.queryType)
+	for _, answer := range s.response.answers {
+		fmt.Fprintf(&b, "%s\n", answer)
+	}
+
+	if s.verificationStatus == 1 {
+		b.WriteString("\n;; VERDICT: PASS\n")
+	} else {
+		b.WriteString("\n;; VERDICT: FAIL\n")
+		for _, failure := range s.failures {
+			fmt.Fprintf(&b, ";; REASON: %s\n", failure)
+		}
+	}
+	_, err = io.WriteString(w, b.String())
+	return errors.Wrap(err, "Cannot write the query result")
+}
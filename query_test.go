@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseQueryArgs(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		args      []string
+		domain    string
+		queryType string
+		resolvers []string
+		wantErr   bool
+	}{
+		"Domain":              {[]string{"thebeat.co"}, "thebeat.co", "", nil, false},
+		"Domain and type":     {[]string{"thebeat.co", "NS"}, "thebeat.co", "NS", nil, false},
+		"Resolver first":      {[]string{"@8.8.8.8", "thebeat.co", "MX"}, "thebeat.co", "MX", []string{"8.8.8.8"}, false},
+		"Several resolvers":   {[]string{"thebeat.co", "@8.8.8.8", "A", "@[2001:db8::1]:5353"}, "thebeat.co", "A", []string{"8.8.8.8", "[2001:db8::1]:5353"}, false},
+		"No domain":           {[]string{"@8.8.8.8"}, "", "", nil, true},
+		"Empty resolver":      {[]string{"thebeat.co", "@"}, "", "", nil, true},
+		"Too many arguments":  {[]string{"thebeat.co", "A", "NS"}, "", "", nil, true},
+		"No arguments at all": {nil, "", "", nil, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var req YamlRequest
+			err := parseQueryArgs(tt.args, &req)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.domain, req.Domain)
+			assert.Equal(t, tt.queryType, req.QueryType)
+			assert.Equal(t, tt.resolvers, req.Resolvers)
+		})
+	}
+}
+
+func TestWriteQueryResult(t *testing.T) {
+	t.Parallel()
+	client := &consensusClientTest{answers: map[string][]string{"10.0.0.1:53": {"1.1.1.1", "2.2.2.2"}}}
+
+	s := newTestCheckStream("10.0.0.1", "1.1.1.1", "2.2.2.2")
+	err := s.query(client)
+	require.NoError(t, err)
+	var out bytes.Buffer
+	require.NoError(t, writeQueryResult(&out, s, err))
+	assert.Contains(t, out.String(), ";; SERVER: 10.0.0.1:53 (udp)\n")
+	assert.Contains(t, out.String(), ";; ANSWER SECTION:\nthebeat.co.\t60\tIN\tA\t1.1.1.1\n")
+	assert.Contains(t, out.String(), ";; RTT: 1ms\n;; ATTEMPTS: 1\n")
+	assert.Contains(t, out.String(), ";; VERIFIED ANSWERS (A):\n1.1.1.1\n2.2.2.2\n")
+	assert.Contains(t, out.String(), ";; VERDICT: PASS\n")
+
+	s = newTestCheckStream("10.0.0.1", "3.3.3.3")
+	err = s.query(client)
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, writeQueryResult(&out, s, err))
+	assert.Contains(t, out.String(), ";; VERDICT: FAIL\n;; REASON: Expected answers:[3.3.3.3]")
+
+	// Every check that fails is a reason of its own
+	nxdomain := NXDOMAIN
+	s = newTestCheckStream("10.0.0.1", "3.3.3.3")
+	s.request.expectedResponseCode = &nxdomain
+	err = s.query(client)
+	require.NoError(t, err)
+	out.Reset()
+	require.NoError(t, writeQueryResult(&out, s, err))
+	assert.Contains(t, out.String(), ";; VERDICT: FAIL\n;; REASON: Expected respond code:<NXDOMAIN>")
+	assert.Contains(t, out.String(), "\n;; REASON: Expected answers:[3.3.3.3]")
+	assert.Len(t, s.failures, 2)
+
+	s = newTestCheckStream("10.0.0.2")
+	out.Reset()
+	require.NoError(t, writeQueryResult(&out, s, errors.New("no answer")))
+	assert.Equal(t, ";; SERVER: 10.0.0.2 (udp)\n;; ATTEMPTS: 0\n;; ERROR: no answer\n", out.String())
+}
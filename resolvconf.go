@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolvConfPath is where the system resolver is configured.
+const resolvConfPath = "/etc/resolv.conf"
+
+// resolvConf holds the servers a query is sent to, in the order they are
+// tried, and the options of failing over between them.
+type resolvConf struct {
+	// servers are the addresses of the servers, including the port.
+	servers []string
+	// timeout is how long to wait for each server before trying the next
+	// one, zero keeps the timeout of the client.
+	timeout time.Duration
+	// attempts is how many times the list of servers is tried.
+	attempts int
+	// rotate spreads the queries across the servers instead of always
+	// starting with the first one.
+	rotate bool
+	// search is the list of domains short names are expanded with.
+	search []string
+	// ndots is how many dots a name needs to be tried as is before being
+	// expanded with the search list.
+	ndots int
+}
+
+// parseResolvConf parses the servers and options of a resolv.conf file.
+func parseResolvConf(data []byte) (*resolvConf, error) {
+	cc, err := dns.ClientConfigFromReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot parse the resolv.conf")
+	}
+	if len(cc.Servers) == 0 {
+		return nil, errors.New("resolv.conf lists no nameservers")
+	}
+
+	conf := &resolvConf{
+		servers:  make([]string, 0, len(cc.Servers)),
+		timeout:  time.Duration(cc.Timeout) * time.Second,
+		attempts: cc.Attempts,
+		search:   cc.Search,
+		ndots:    cc.Ndots,
+	}
+	for _, s := range cc.Servers {
+		conf.servers = append(conf.servers, net.JoinHostPort(s, cc.Port))
+	}
+
+	// The rotate option is parsed but ignored by the dns package
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) > 0 && f[0] == "options" && slices.Contains(f[1:], "rotate") {
+			conf.rotate = true
+		}
+	}
+
+	return conf, nil
+}
+
+// systemResolver keeps the parsed resolv.conf of the system in memory and
+// reloads it when the file changes.
+type systemResolver struct {
+	path    string
+	mu      sync.RWMutex
+	conf    *resolvConf
+	err     error
+	next    atomic.Uint32
+	watcher *fsnotify.Watcher
+}
+
+var (
+	defaultSystemResolverOnce sync.Once
+	defaultSystemResolver     *systemResolver
+)
+
+// getSystemResolver returns the resolver configured in /etc/resolv.conf,
+// which is loaded and watched for changes the first time it's needed.
+func getSystemResolver() *systemResolver {
+	defaultSystemResolverOnce.Do(func() {
+		defaultSystemResolver = newSystemResolver(resolvConfPath)
+		if err := defaultSystemResolver.watch(); err != nil {
+			log.Errorf("Cannot watch %s for changes: %v", resolvConfPath, err)
+		}
+	})
+	return defaultSystemResolver
+}
+
+func newSystemResolver(path string) *systemResolver {
+	s := &systemResolver{path: filepath.Clean(path)}
+	s.load()
+	return s
+}
+
+// load reads and parses the resolv.conf. When reloading fails, the
+// previous configuration is kept.
+func (s *systemResolver) load() {
+	data, err := os.ReadFile(s.path)
+	var conf *resolvConf
+	if err == nil {
+		conf, err = parseResolvConf(data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		err = errors.Wrapf(err, "Cannot initialize the local resolver from %s", s.path)
+		if s.conf != nil {
+			log.Errorf("Keeping the previous local resolver configuration: %v", err)
+			return
+		}
+		s.err = err
+		return
+	}
+	s.conf, s.err = conf, nil
+	log.Debugf("Loaded local resolver configuration from %s with servers:<%s>", s.path, strings.Join(conf.servers, ","))
+}
+
+// watch reloads the resolv.conf whenever it changes. The directory is
+// watched instead of the file, so files replaced by renaming a new one over
+// them are picked up too.
+func (s *systemResolver) watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "Cannot create file watcher")
+	}
+	if err := watcher.Add(filepath.Dir(s.path)); err != nil {
+		watcher.Close()
+		return errors.Wrapf(err, "Cannot watch %s", filepath.Dir(s.path))
+	}
+	s.watcher = watcher
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == s.path && event.Has(fsnotify.Write|fsnotify.Create) {
+					log.Infof("%s changed, reloading the local resolver configuration", s.path)
+					s.load()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Errorf("Error watching %s: %v", s.path, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// close stops watching the resolv.conf for changes.
+func (s *systemResolver) close() {
+	if s.watcher != nil {
+		s.watcher.Close()
+	}
+}
+
+// resolvers returns the servers of the system resolver in the order they
+// are tried. With the rotate option, every call starts from the next server.
+func (s *systemResolver) resolvers() (*resolvConf, error) {
+	s.mu.RLock()
+	conf, err := s.conf, s.err
+	s.mu.RUnlock()
+	if conf == nil {
+		return nil, err
+	}
+	if !conf.rotate || len(conf.servers) < 2 {
+		return conf, nil
+	}
+
+	start := int(s.next.Add(1)-1) % len(conf.servers)
+	rotated := *conf
+	rotated.servers = append(slices.Clone(conf.servers[start:]), conf.servers[:start]...)
+	return &rotated, nil
+}
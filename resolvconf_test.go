@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		input    string
+		expected *resolvConf
+		wantErr  bool
+	}{
+		"Defaults": {
+			input:    "nameserver 10.0.0.1\n",
+			expected: &resolvConf{servers: []string{"10.0.0.1:53"}, timeout: 5 * time.Second, attempts: 2, search: []string{}, ndots: 1},
+		},
+		"Options": {
+			input:    "search svc.cluster.local\nnameserver 10.0.0.1\nnameserver 10.0.0.2\noptions ndots:5 timeout:1 attempts:3 rotate\n",
+			expected: &resolvConf{servers: []string{"10.0.0.1:53", "10.0.0.2:53"}, timeout: time.Second, attempts: 3, rotate: true, search: []string{"svc.cluster.local"}, ndots: 5},
+		},
+		"IPv6": {
+			input:    "nameserver ::1\nnameserver 10.0.0.1\n",
+			expected: &resolvConf{servers: []string{"[::1]:53", "10.0.0.1:53"}, timeout: 5 * time.Second, attempts: 2, search: []string{}, ndots: 1},
+		},
+		"No nameservers": {
+			input:   "search svc.cluster.local\noptions rotate\n",
+			wantErr: true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			conf, err := parseResolvConf([]byte(tt.input))
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, conf)
+		})
+	}
+}
+
+func writeResolvConf(t *testing.T, path, content string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+}
+
+func TestSystemResolverRotate(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	writeResolvConf(t, path, "nameserver 10.0.0.1\nnameserver 10.0.0.2\nnameserver 10.0.0.3\noptions rotate\n")
+	s := newSystemResolver(path)
+
+	for _, first := range []string{"10.0.0.1:53", "10.0.0.2:53", "10.0.0.3:53", "10.0.0.1:53"} {
+		conf, err := s.resolvers()
+		require.NoError(t, err)
+		require.Len(t, conf.servers, 3)
+		assert.Equal(t, first, conf.servers[0])
+	}
+}
+
+func TestSystemResolverReload(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	s := newSystemResolver(path)
+	_, err := s.resolvers()
+	require.Error(t, err)
+
+	require.NoError(t, s.watch())
+	t.Cleanup(s.close)
+
+	writeResolvConf(t, path, "nameserver 10.0.0.1\n")
+	require.Eventually(t, func() bool {
+		conf, err := s.resolvers()
+		return err == nil && conf.servers[0] == "10.0.0.1:53"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Files replaced through a rename are picked up too
+	tmp := filepath.Join(filepath.Dir(path), "resolv.conf.tmp")
+	writeResolvConf(t, tmp, "nameserver 10.0.0.2\n")
+	require.NoError(t, os.Rename(tmp, path))
+	require.Eventually(t, func() bool {
+		conf, err := s.resolvers()
+		return err == nil && conf.servers[0] == "10.0.0.2:53"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A broken file keeps the previous configuration
+	writeResolvConf(t, path, "options rotate\n")
+	s.load()
+	conf, err := s.resolvers()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.2:53"}, conf.servers)
+}
+
+// failoverClientTest answers with the rcode configured for each server,
+// failing for the servers without one.
+type failoverClientTest struct {
+	rcodes  map[string]int
+	queried []string
+}
+
+func (c *failoverClientTest) query(q *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	c.queried = append(c.queried, server)
+	rcode, ok := c.rcodes[server]
+	if !ok {
+		return nil, 0, errors.Errorf("no answer from %s", server)
+	}
+	m := new(dns.Msg)
+	m.SetRcode(q, rcode)
+	if rcode == dns.RcodeSuccess {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("127.0.0.1"),
+		})
+	}
+	return m, time.Millisecond, nil
+}
+
+func TestQueryFailover(t *testing.T) {
+	t.Parallel()
+	servers := []string{"10.0.0.1:53", "10.0.0.2:53", "10.0.0.3:53"}
+	tests := map[string]struct {
+		rcodes   map[string]int
+		attempts int
+		server   string
+		queried  int
+		rcode    rCode
+		wantErr  bool
+	}{
+		"First answers": {
+			rcodes:   map[string]int{"10.0.0.1:53": dns.RcodeSuccess, "10.0.0.2:53": dns.RcodeSuccess},
+			attempts: 2,
+			server:   "10.0.0.1:53",
+			queried:  1,
+			rcode:    NOERROR,
+		},
+		"Failover on error": {
+			rcodes:   map[string]int{"10.0.0.3:53": dns.RcodeSuccess},
+			attempts: 2,
+			server:   "10.0.0.3:53",
+			queried:  3,
+			rcode:    NOERROR,
+		},
+		"Failover on SERVFAIL": {
+			rcodes:   map[string]int{"10.0.0.1:53": dns.RcodeServerFailure, "10.0.0.2:53": dns.RcodeNameError},
+			attempts: 2,
+			server:   "10.0.0.2:53",
+			queried:  2,
+			rcode:    NXDOMAIN,
+		},
+		"Every server fails": {
+			rcodes:   map[string]int{"10.0.0.2:53": dns.RcodeServerFailure},
+			attempts: 2,
+			server:   "10.0.0.2:53",
+			queried:  6,
+			rcode:    SERVFAIL,
+		},
+		"No server answers": {
+			rcodes:   map[string]int{},
+			attempts: 3,
+			queried:  9,
+			wantErr:  true,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "resolv.conf")
+			writeResolvConf(t, path, "nameserver 10.0.0.1\nnameserver 10.0.0.2\nnameserver 10.0.0.3\n")
+			s := newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A"}, 100)
+			s.systemResolver = newSystemResolver(path)
+			c := &failoverClientTest{rcodes: tt.rcodes}
+
+			conf, err := s.constructResolvers()
+			require.NoError(t, err)
+			require.Equal(t, servers, conf.servers)
+			conf.attempts = tt.attempts
+			s.systemResolver.conf = conf
+
+			err = s.query(c)
+			assert.Len(t, c.queried, tt.queried)
+			assert.Equal(t, tt.server, s.server)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.rcode, s.response.code)
+		})
+	}
+}
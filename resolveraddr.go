@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// resolverAddr is the address of a configured resolver, in any of the forms
+// host, host:port, a bare IPv6 address or [IPv6]:port, where host is an IP
+// address or a hostname. Hostnames are resolved to an IP address once,
+// and again when the resolver stops answering.
+type resolverAddr struct {
+	host string
+	port string
+
+	mu sync.RWMutex
+	ip string
+}
+
+// newResolverAddr parses the address of a resolver, using the default port
+// when the address has none.
+func newResolverAddr(address, defaultPort string) (*resolverAddr, error) {
+	host, port := address, defaultPort
+	switch {
+	case address == "":
+		return nil, errors.New("resolver address cannot be empty")
+	case strings.HasPrefix(address, "["):
+		if strings.HasSuffix(address, "]") {
+			host = address[1 : len(address)-1]
+		} else {
+			var err error
+			if host, port, err = net.SplitHostPort(address); err != nil {
+				return nil, errors.Wrapf(err, "%s is not a valid resolver address", address)
+			}
+		}
+		if !isIPv6(host) {
+			return nil, errors.Errorf("%s is not a valid resolver address, only IPv6 addresses go in brackets", address)
+		}
+	case isIPv6(address):
+		// A bare IPv6 address, its last group is not a port
+	case strings.Contains(address, ":"):
+		var err error
+		if host, port, err = net.SplitHostPort(address); err != nil {
+			return nil, errors.Wrapf(err, "%s is not a valid resolver address", address)
+		}
+	}
+
+	if p, err := strconv.ParseUint(port, 10, 16); err != nil || p == 0 {
+		return nil, errors.Errorf("%s is not a valid resolver address, %q is not a valid port", address, port)
+	}
+
+	a := &resolverAddr{host: host, port: port}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		a.ip = ip.String()
+		return a, nil
+	}
+	if !validHostname(host) {
+		return nil, errors.Errorf("%s is not a valid resolver address, %q is neither an IP address nor a hostname", address, host)
+	}
+
+	return a, nil
+}
+
+// isIPv6 checks if the host is an IPv6 address, optionally with a zone.
+func isIPv6(host string) bool {
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.Is6()
+}
+
+// validHostname checks if the name is made of valid hostname labels.
+func validHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	// A numeric top level label means a mistyped IP address
+	if strings.Trim(labels[len(labels)-1], "0123456789") == "" {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isHostname checks if the resolver is configured by hostname instead of
+// IP address.
+func (a *resolverAddr) isHostname() bool {
+	_, err := netip.ParseAddr(a.host)
+	return err != nil
+}
+
+// resolve looks up the IP address of a resolver configured by hostname.
+// When the lookup fails, the previous IP address is kept and, if there is
+// none, the hostname is left to be resolved when dialing.
+func (a *resolverAddr) resolve() error {
+	if !a.isHostname() {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", a.host)
+	if err != nil {
+		return errors.Wrapf(err, "Cannot resolve resolver %s", a.host)
+	}
+	if len(ips) == 0 {
+		return errors.Errorf("Cannot resolve resolver %s, no addresses found", a.host)
+	}
+
+	ip := ips[0].Unmap().String()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ip != ip {
+		log.Infof("Resolver:<%s> resolved to %s", a.host, ip)
+	}
+	a.ip = ip
+	return nil
+}
+
+// String returns the address the resolver is dialed at.
+func (a *resolverAddr) String() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.ip == "" {
+		return net.JoinHostPort(a.host, a.port)
+	}
+	return net.JoinHostPort(a.ip, a.port)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewResolverAddr(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		input    string
+		expected string
+		hostname bool
+		wantErr  bool
+	}{
+		"IPv4":                 {"10.0.0.1", "10.0.0.1:53", false, false},
+		"IPv4 with port":       {"10.0.0.1:5353", "10.0.0.1:5353", false, false},
+		"IPv6":                 {"2001:db8::1", "[2001:db8::1]:53", false, false},
+		"IPv6 in brackets":     {"[2001:db8::1]", "[2001:db8::1]:53", false, false},
+		"IPv6 with port":       {"[2001:db8::1]:5353", "[2001:db8::1]:5353", false, false},
+		"IPv6 with zone":       {"fe80::1%eth0", "[fe80::1%eth0]:53", false, false},
+		"Hostname":             {"coredns.kube-system.svc", "coredns.kube-system.svc:53", true, false},
+		"Hostname with port":   {"coredns.kube-system.svc:5353", "coredns.kube-system.svc:5353", true, false},
+		"Empty":                {"", "", false, true},
+		"Invalid port":         {"10.0.0.1:dns", "", false, true},
+		"Port out of range":    {"10.0.0.1:65536", "", false, true},
+		"Zero port":            {"10.0.0.1:0", "", false, true},
+		"Empty port":           {"10.0.0.1:", "", false, true},
+		"IPv4 in brackets":     {"[10.0.0.1]:53", "", false, true},
+		"Unclosed bracket":     {"[2001:db8::1:53", "", false, true},
+		"Too many colons":      {"dns.test:53:53", "", false, true},
+		"Mistyped IPv4":        {"10.0.0.256", "", false, true},
+		"URL":                  {"https://dns.google/dns-query", "", false, true},
+		"Invalid hostname":     {"dns..test", "", false, true},
+		"Space in hostname":    {"dns test", "", false, true},
+		"Leading dash in host": {"-dns.test", "", false, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			addr, err := newResolverAddr(tt.input, "53")
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, addr.String())
+			assert.Equal(t, tt.hostname, addr.isHostname())
+		})
+	}
+}
+
+func TestResolverAddrResolve(t *testing.T) {
+	t.Parallel()
+	addr, err := newResolverAddr("localhost:5353", "53")
+	require.NoError(t, err)
+	if err := addr.resolve(); err != nil {
+		t.Skipf("Cannot resolve localhost: %v", err)
+	}
+	assert.Contains(t, []string{"127.0.0.1:5353", "[::1]:5353"}, addr.String())
+
+	// IP addresses are never looked up
+	addr, err = newResolverAddr("10.0.0.1", "53")
+	require.NoError(t, err)
+	require.NoError(t, addr.resolve())
+	assert.Equal(t, "10.0.0.1:53", addr.String())
+}
+
+func TestDNSClientServerName(t *testing.T) {
+	t.Parallel()
+	addr := &resolverAddr{host: "dns.test", port: "853", ip: "127.0.0.1"}
+	c := newDNSClient(&dnsRequest{transport: transportTLS, resolverAddrs: map[string]*resolverAddr{"dns.test": addr}})
+	assert.Equal(t, "dns.test", c.serverName("127.0.0.1:853"))
+	assert.Equal(t, "10.0.0.1", c.serverName("10.0.0.1:853"))
+}
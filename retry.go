@@ -0,0 +1,62 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultRetryBackoff is how long to wait before the first retry when
+	// no backoff is configured.
+	defaultRetryBackoff = time.Second
+	// maxRetries bounds the retries of a request, so a run can't outlast
+	// its interval by much.
+	maxRetries = 10
+)
+
+// newRetries validates the number of times a failed query is retried.
+func newRetries(retries *int) (int, error) {
+	if retries == nil {
+		return 0, nil
+	}
+	if *retries < 0 || *retries > maxRetries {
+		return 0, errors.Errorf("retries needs to be between 0 and %d, got %d", maxRetries, *retries)
+	}
+	return *retries, nil
+}
+
+// newPositiveDuration parses a duration setting of a request that has to
+// be positive, falling back to the default when it's empty.
+func newPositiveDuration(setting, value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.Wrapf(err, "%s is not a valid duration for %s", value, setting)
+	}
+	if d <= 0 {
+		return 0, errors.Errorf("%s needs to be positive, got %s", setting, value)
+	}
+	return d, nil
+}
+
+// retry calls the exchange until it succeeds or the retries of the request
+// run out, waiting the retry backoff before the first retry and twice as
+// long before every next one. It returns how many times the exchange was
+// called and its last error, so only the final outcome counts.
+func (d *dnsStream) retry(exchange func() error) (int, error) {
+	backoff := d.request.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := exchange()
+		if err == nil || attempt > d.request.retries {
+			return attempt, err
+		}
+		log.Infof("Attempt %d of %d for domain:<%s> and DNS query type:<%s> failed, retrying in %s: %v",
+			attempt, d.request.retries+1, d.request.domain, d.request.queryType, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyClientTest fails the first queries and answers the rest with
+// 127.0.0.1.
+type flakyClientTest struct {
+	failures int
+	queries  int
+}
+
+func (c *flakyClientTest) query(q *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	c.queries++
+	if c.queries <= c.failures {
+		return nil, 0, errors.New("i/o timeout")
+	}
+	m := new(dns.Msg)
+	m.SetReply(q)
+	m.Answer = append(m.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: q.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("127.0.0.1"),
+	})
+	return m, time.Millisecond, nil
+}
+
+func TestQueryRetries(t *testing.T) {
+	t.Parallel()
+	resolver := "10.0.0.1"
+	tests := map[string]struct {
+		failures int
+		retries  int
+		attempts int
+		wantErr  bool
+	}{
+		"No failures":          {0, 2, 1, false},
+		"Recovers after retry": {2, 2, 3, false},
+		"Retries run out":      {3, 2, 3, true},
+		"Without retries":      {1, 0, 1, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dr := &dnsRequest{domain: "thebeat.co", queryType: "A", resolver: &resolver, expectedResponse: []string{"127.0.0.1"}, retries: tt.retries, retryBackoff: time.Millisecond}
+			s := newDNSStream(dr, 100)
+			c := &flakyClientTest{failures: tt.failures}
+			err := s.query(c)
+			assert.Equal(t, tt.attempts, s.attempts)
+			assert.Equal(t, tt.attempts, c.queries)
+			if tt.wantErr {
+				require.Error(t, err)
+				assert.Zero(t, s.verificationStatus)
+				assert.Zero(t, s.rtt)
+				return
+			}
+			require.NoError(t, err)
+			assert.InDelta(t, 1, s.verificationStatus, 0.0001)
+		})
+	}
+}
+
+func TestQueryFailureAfterSuccess(t *testing.T) {
+	t.Parallel()
+	resolver := "10.0.0.1"
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", resolver: &resolver, expectedResponse: []string{"127.0.0.1"}, check: checkRRSIGExpiry}
+	s := newDNSStream(dr, 100)
+	s.verificationStatus, s.rtt, s.dnssecStatus = 1, time.Millisecond, 1
+	s.rrsigExpiry = map[uint16]time.Duration{12345: time.Hour}
+
+	// The resolver goes dark after a run that verified the response
+	require.Error(t, s.query(&flakyClientTest{failures: 1}))
+	assert.Zero(t, s.verificationStatus)
+	assert.Zero(t, s.rtt)
+	assert.Zero(t, s.dnssecStatus)
+	assert.Empty(t, s.rrsigExpiry)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	t.Parallel()
+	s := newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A", retries: 3, retryBackoff: 10 * time.Millisecond}, 100)
+
+	start := time.Now()
+	attempts, err := s.retry(func() error { return errors.New("i/o timeout") })
+	require.Error(t, err)
+	assert.Equal(t, 4, attempts)
+	// The backoff doubles before every retry, 10ms + 20ms + 40ms
+	assert.GreaterOrEqual(t, time.Since(start), 70*time.Millisecond)
+}
+
+func TestQueryConsensusRetries(t *testing.T) {
+	t.Parallel()
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", resolvers: []string{"10.0.0.1", "10.0.0.2"}, check: checkConsensus, quorum: 2, retries: 1, retryBackoff: time.Millisecond}
+	s := newDNSStream(dr, 100)
+	require.NoError(t, s.query(&flakyClientTest{failures: 1}))
+	assert.Equal(t, 3, s.attempts)
+	assert.Equal(t, 2, s.agreeing)
+}
+
+func TestNewRetries(t *testing.T) {
+	t.Parallel()
+	r, err := newRetries(nil)
+	require.NoError(t, err)
+	assert.Zero(t, r)
+
+	r, err = newRetries(intPtr(3))
+	require.NoError(t, err)
+	assert.Equal(t, 3, r)
+
+	_, err = newRetries(intPtr(-1))
+	require.Error(t, err)
+	_, err = newRetries(intPtr(maxRetries + 1))
+	require.Error(t, err)
+}
+
+func TestRequestTimeout(t *testing.T) {
+	t.Parallel()
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A"}
+	assert.Equal(t, DefaultTimeout, dr.queryTimeout())
+	assert.Zero(t, newDNSClient(dr).client.Timeout)
+
+	dr.timeout = 500 * time.Millisecond
+	c := newDNSClient(dr)
+	assert.Equal(t, dr.timeout, c.client.Timeout)
+	assert.Equal(t, dr.timeout, c.tcpClient.Timeout)
+	assert.Equal(t, dr.timeout, newDoHClient(dr).client.Timeout)
+	assert.Equal(t, dr.timeout, newSystemClient(dr).timeout)
+}
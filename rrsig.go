@@ -0,0 +1,73 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// defaultRRSIGExpiryWarning is how long before the signatures of a
+// rrsig-expiry check expire that its verification starts failing.
+const defaultRRSIGExpiryWarning = 7 * 24 * time.Hour
+
+// newRRSIGExpiryWarning parses the warning threshold of a rrsig-expiry
+// check, falling back to defaultRRSIGExpiryWarning when it's empty.
+func newRRSIGExpiryWarning(threshold string) (time.Duration, error) {
+	if threshold == "" {
+		return defaultRRSIGExpiryWarning, nil
+	}
+	d, err := time.ParseDuration(threshold)
+	if err != nil {
+		return 0, errors.Wrapf(err, "%s is not a valid RRSIG expiry warning threshold", threshold)
+	}
+	if d < 0 {
+		return 0, errors.Errorf("RRSIG expiry warning threshold cannot be negative, got %s", threshold)
+	}
+	return d, nil
+}
+
+// parseRRSIGExpiry stores how long until the RRSIG records that cover the
+// requested type expire, per key tag. When a key signed more than one
+// RRSIG, the one that expires first is kept.
+func (d *dnsStream) parseRRSIGExpiry(now time.Time) {
+	expiry := map[uint16]time.Duration{}
+	qtype := d.request.qtype()
+	for _, rr := range d.response.rawResponse.Answer {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok || sig.TypeCovered != qtype {
+			continue
+		}
+		left := rrsigExpiration(sig, now).Sub(now)
+		if current, ok := expiry[sig.KeyTag]; !ok || left < current {
+			expiry[sig.KeyTag] = left
+		}
+	}
+	d.rrsigExpiry = expiry
+}
+
+// rrsigExpiration returns the expiration time of the signature. RRSIG
+// timestamps are serial numbers (RFC 4034 3.1.5), so the expiration is the
+// one closest to now that has the same lower 32 bits.
+func rrsigExpiration(sig *dns.RRSIG, now time.Time) time.Time {
+	delta := int64(int32(sig.Expiration - uint32(now.Unix()))) //nolint:gosec
+	return time.Unix(now.Unix()+delta, 0)
+}
+
+// verifyRRSIGs records a failure when the answer wasn't signed and for
+// every signature that expires within the warning threshold.
+func (d *dnsStream) verifyRRSIGs() {
+	if len(d.rrsigExpiry) == 0 {
+		d.fail("Expected RRSIG records for quering domain:<%s> and DNS query type:<%s> but got none",
+			d.request.domain, d.request.queryType)
+		return
+	}
+	for _, keyTag := range slices.Sorted(maps.Keys(d.rrsigExpiry)) {
+		if left := d.rrsigExpiry[keyTag]; left < d.request.rrsigExpiryWarning {
+			d.fail("RRSIG of key:<%d> for quering domain:<%s> and DNS query type:<%s> expires in %s, less than %s",
+				keyTag, d.request.domain, d.request.queryType, left, d.request.rrsigExpiryWarning)
+		}
+	}
+}
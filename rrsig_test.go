@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRRSIG(keyTag uint16, covered uint16, expiration time.Time) *dns.RRSIG {
+	return &dns.RRSIG{
+		Hdr:         dns.RR_Header{Name: "thebeat.co.", Rrtype: dns.TypeRRSIG, Class: dns.ClassINET},
+		TypeCovered: covered,
+		KeyTag:      keyTag,
+		Expiration:  uint32(expiration.Unix()), //nolint:gosec
+	}
+}
+
+func TestParseRRSIGExpiry(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(time.Now().Unix(), 0)
+	dr := &dnsRequest{domain: "thebeat.co", queryType: "A", check: checkRRSIGExpiry, rrsigExpiryWarning: 7 * 24 * time.Hour}
+	s := newDNSStream(dr, 100)
+	s.response.rawResponse = &dns.Msg{Answer: []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "thebeat.co.", Rrtype: dns.TypeA}, A: net.ParseIP("127.0.0.1")},
+		newTestRRSIG(1000, dns.TypeA, now.Add(10*24*time.Hour)),
+		newTestRRSIG(1000, dns.TypeA, now.Add(9*24*time.Hour)),
+		newTestRRSIG(2000, dns.TypeA, now.Add(20*24*time.Hour)),
+		// Signatures of other types are ignored
+		newTestRRSIG(3000, dns.TypeCNAME, now.Add(time.Hour)),
+	}}
+
+	s.parseRRSIGExpiry(now)
+
+	require.Len(t, s.rrsigExpiry, 2)
+	assert.Equal(t, 9*24*time.Hour, s.rrsigExpiry[1000])
+	assert.Equal(t, 20*24*time.Hour, s.rrsigExpiry[2000])
+	assert.True(t, s.isResponseLegit())
+
+	// A signature that expires within the warning threshold fails the verification
+	s.response.rawResponse.Answer = append(s.response.rawResponse.Answer, newTestRRSIG(2000, dns.TypeA, now.Add(6*24*time.Hour)))
+	s.parseRRSIGExpiry(now)
+	assert.False(t, s.isResponseLegit())
+	require.Len(t, s.failures, 1)
+	assert.Contains(t, s.failures[0], "RRSIG of key:<2000>")
+
+	// So does an answer without signatures
+	s.response.rawResponse.Answer = s.response.rawResponse.Answer[:1]
+	s.parseRRSIGExpiry(now)
+	assert.Empty(t, s.rrsigExpiry)
+	assert.False(t, s.isResponseLegit())
+	require.Len(t, s.failures, 1)
+	assert.Contains(t, s.failures[0], "got none")
+}
+
+func TestRRSIGExpiration(t *testing.T) {
+	t.Parallel()
+	now := time.Unix(1700000000, 0)
+	tests := map[string]time.Time{
+		"Future expiration": now.Add(24 * time.Hour),
+		"Past expiration":   now.Add(-24 * time.Hour),
+	}
+	for name, expiration := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			sig := newTestRRSIG(1000, dns.TypeA, expiration)
+			assert.Equal(t, expiration, rrsigExpiration(sig, now))
+		})
+	}
+}
+
+func TestNewRRSIGExpiryWarning(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		"Default":  {"", defaultRRSIGExpiryWarning, false},
+		"Hours":    {"72h", 72 * time.Hour, false},
+		"Invalid":  {"3d", 0, true},
+		"Negative": {"-1h", 0, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			d, err := newRRSIGExpiryWarning(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, d)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"go.yaml.in/yaml/v3"
+)
+
+// deprecatedKeys maps keys that are still accepted, but will be removed, to
+// the keys that replace them.
+var deprecatedKeys = map[string]string{
+	"expectedRespone": "expectedResponse",
+	"query":           "queryType",
+}
+
+// configIssue is a key of the config that is unknown or deprecated.
+type configIssue struct {
+	// section is where the key was found, e.g. requests[2].
+	section string
+	line    int
+	message string
+}
+
+func (i configIssue) String() string {
+	return fmt.Sprintf("%s (line %d): %s", i.section, i.line, i.message)
+}
+
+// yamlKeys returns the yaml keys of the fields of a struct.
+func yamlKeys(v any) []string {
+	t := reflect.TypeOf(v)
+	keys := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		if key, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// parseYamlConfig decodes the yaml config. Keys that differ from the known
+// ones only in case and deprecated keys are renamed to the known ones.
+// Unknown keys fail the config when strict is set and are only logged
+// otherwise, while deprecated keys are always logged.
+func parseYamlConfig(data []byte, strict bool) (*YamlRequests, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, errors.Wrap(err, "Unable to parse config yaml")
+	}
+
+	var yr YamlRequests
+	if len(root.Content) == 0 {
+		return &yr, nil
+	}
+
+	unknown, deprecated := checkConfigKeys(root.Content[0])
+	for _, issue := range deprecated {
+		log.Warn(issue.String())
+	}
+	if len(unknown) > 0 {
+		if strict {
+			messages := make([]string, 0, len(unknown))
+			for _, issue := range unknown {
+				messages = append(messages, issue.String())
+			}
+			return nil, errors.Errorf("Unknown keys in config yaml: %s", strings.Join(messages, "; "))
+		}
+		for _, issue := range unknown {
+			log.Warn(issue.String())
+		}
+	}
+
+	if err := root.Decode(&yr); err != nil {
+		return nil, errors.Wrap(err, "Unable to decode config yaml into struct")
+	}
+
+	return &yr, nil
+}
+
+// checkConfigKeys checks the keys of the config, its defaults block and
+// each of its requests against the known ones, returning the unknown and
+// deprecated keys found.
+func checkConfigKeys(root *yaml.Node) ([]configIssue, []configIssue) {
+	var unknown, deprecated []configIssue
+	check := func(node *yaml.Node, section string, known []string) {
+		u, d := checkKeys(node, section, known)
+		unknown = append(unknown, u...)
+		deprecated = append(deprecated, d...)
+	}
+
+	check(root, "config", yamlKeys(YamlRequests{}))
+	if root.Kind != yaml.MappingNode {
+		return unknown, deprecated
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		value := root.Content[i+1]
+		switch root.Content[i].Value {
+		case "defaults":
+			check(value, "defaults", yamlKeys(YamlDefaults{}))
+		case "requests":
+			if value.Kind != yaml.SequenceNode {
+				continue
+			}
+			for j, req := range value.Content {
+				check(req, fmt.Sprintf("requests[%d]", j), yamlKeys(YamlRequest{}))
+			}
+		}
+	}
+
+	return unknown, deprecated
+}
+
+// checkKeys checks the keys of a yaml mapping against the known ones.
+// Keys that differ only in case and deprecated keys are renamed in place,
+// so they decode like the known ones.
+func checkKeys(node *yaml.Node, section string, known []string) ([]configIssue, []configIssue) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	var unknown, deprecated []configIssue
+	for i := 0; i < len(node.Content); i += 2 {
+		key := node.Content[i]
+		if canonical, ok := findKey(known, key.Value); ok {
+			key.Value = canonical
+			continue
+		}
+		if replacement, ok := deprecatedKeys[key.Value]; ok && hasKey(known, replacement) {
+			deprecated = append(deprecated, configIssue{section, key.Line,
+				fmt.Sprintf("%q is deprecated, use %q instead", key.Value, replacement)})
+			if mappingHasKey(node, replacement) {
+				unknown = append(unknown, configIssue{section, key.Line,
+					fmt.Sprintf("%q cannot be set along with %q", key.Value, replacement)})
+			}
+			key.Value = replacement
+			continue
+		}
+
+		message := fmt.Sprintf("unknown key %q", key.Value)
+		if suggestion := suggestKey(known, key.Value); suggestion != "" {
+			message += fmt.Sprintf(", did you mean %q?", suggestion)
+		}
+		unknown = append(unknown, configIssue{section, key.Line, message})
+	}
+
+	return unknown, deprecated
+}
+
+// findKey returns the known key that matches the key, ignoring case.
+func findKey(known []string, key string) (string, bool) {
+	for _, k := range known {
+		if strings.EqualFold(k, key) {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+func hasKey(known []string, key string) bool {
+	_, ok := findKey(known, key)
+	return ok
+}
+
+// mappingHasKey checks if a yaml mapping sets the key.
+func mappingHasKey(node *yaml.Node, key string) bool {
+	for i := 0; i < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxSuggestionDistance is the most edits a misspelled key can be away
+// from a known key for it to be suggested.
+const maxSuggestionDistance = 3
+
+// suggestKey returns the known key closest to a misspelled one, or an empty
+// string when none is close enough.
+func suggestKey(known []string, key string) string {
+	suggestion, best := "", maxSuggestionDistance+1
+	for _, k := range known {
+		if d := levenshtein(strings.ToLower(k), strings.ToLower(key)); d < best {
+			suggestion, best = k, d
+		}
+	}
+	return suggestion
+}
+
+// levenshtein returns the number of single character insertions, deletions
+// and substitutions needed to change one string into the other.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(rb)]
+}
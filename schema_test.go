@@ -0,0 +1,124 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseYamlConfig(t *testing.T) {
+	t.Parallel()
+	config := `
+defaults:
+  interval: 60
+  labels:
+    Team: sre
+requests:
+  - domain: thebeat.co
+    queryType: NS
+    expectedResponse:
+      - ns-416.awsdns-52.com.
+  - domain: thebeat.co
+    QUERYTYPE: a
+    expectedResponseCode: NOERROR
+`
+	yr, err := parseYamlConfig([]byte(config), true)
+	require.NoError(t, err)
+	require.Len(t, yr.Requests, 2)
+	assert.Equal(t, 60, *yr.Defaults.Interval)
+	assert.Equal(t, map[string]string{"Team": "sre"}, yr.Defaults.Labels)
+	assert.Equal(t, []string{"ns-416.awsdns-52.com."}, yr.Requests[0].ExpectedResponse)
+	assert.Equal(t, "a", yr.Requests[1].QueryType)
+	assert.Equal(t, "NOERROR", *yr.Requests[1].ExpectedResponseCode)
+}
+
+func TestParseYamlConfigDeprecated(t *testing.T) {
+	t.Parallel()
+	config := `
+requests:
+  - domain: google.com
+    query: A
+    expectedRespone:
+      - 127.0.0.1
+`
+	yr, err := parseYamlConfig([]byte(config), true)
+	require.NoError(t, err)
+	require.Len(t, yr.Requests, 1)
+	assert.Equal(t, "A", yr.Requests[0].QueryType)
+	assert.Equal(t, []string{"127.0.0.1"}, yr.Requests[0].ExpectedResponse)
+
+	config = `
+requests:
+  - domain: google.com
+    query: A
+    queryType: AAAA
+`
+	_, err = parseYamlConfig([]byte(config), true)
+	require.ErrorContains(t, err, `requests[0] (line 4): "query" cannot be set along with "queryType"`)
+}
+
+func TestParseYamlConfigUnknownKeys(t *testing.T) {
+	t.Parallel()
+	config := `
+defaults:
+  intervall: 60
+requests:
+  - domain: thebeat.co
+  - domain: thebeat.co
+    expectedResponce:
+      - 127.0.0.1
+    foo: bar
+resolver: 8.8.8.8
+`
+	_, err := parseYamlConfig([]byte(config), true)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `defaults (line 3): unknown key "intervall", did you mean "interval"?`)
+	assert.Contains(t, err.Error(), `requests[1] (line 7): unknown key "expectedResponce", did you mean "expectedResponse"?`)
+	assert.Contains(t, err.Error(), `requests[1] (line 9): unknown key "foo"`)
+	assert.NotContains(t, err.Error(), `"foo", did you mean`)
+	assert.Contains(t, err.Error(), `config (line 10): unknown key "resolver"`)
+
+	// Unknown keys are only logged when the config is not strict
+	yr, err := parseYamlConfig([]byte(config), false)
+	require.NoError(t, err)
+	require.Len(t, yr.Requests, 2)
+	assert.Nil(t, yr.Requests[1].ExpectedResponse)
+}
+
+func TestParseYamlConfigInvalid(t *testing.T) {
+	t.Parallel()
+	yr, err := parseYamlConfig([]byte(""), true)
+	require.NoError(t, err)
+	assert.Empty(t, yr.Requests)
+
+	_, err = parseYamlConfig([]byte("requests:\n  - domain: thebeat.co\n    interval: often\n"), true)
+	require.ErrorContains(t, err, "line 3")
+
+	_, err = parseYamlConfig([]byte("requests: [\n"), true)
+	require.Error(t, err)
+}
+
+func TestSuggestKey(t *testing.T) {
+	t.Parallel()
+	known := yamlKeys(YamlRequest{})
+	tests := map[string]string{
+		"resolve":          "resolver",
+		"expectedRespnse":  "expectedResponse",
+		"querytpe":         "queryType",
+		"intervals":        "interval",
+		"completely-wrong": "",
+	}
+	for key, expected := range tests {
+		assert.Equal(t, expected, suggestKey(known, key), key)
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, 0, levenshtein("query", "query"))
+	assert.Equal(t, 1, levenshtein("query", "querys"))
+	assert.Equal(t, 1, levenshtein("expectedRespone", "expectedResponse"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+	assert.Equal(t, 5, levenshtein("", "query"))
+}
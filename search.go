@@ -0,0 +1,75 @@
+package main
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+)
+
+// searchQueryError is the rcode recorded for search list queries that got
+// no response at all.
+const searchQueryError = "error"
+
+// searchQuery holds the outcome of one of the queries made while expanding
+// a name with the search list.
+type searchQuery struct {
+	name string
+	rtt  time.Duration
+	// rcode is the response code of the query, or searchQueryError.
+	rcode string
+}
+
+// nameList returns the names a stub resolver tries for the name, in order,
+// following the search list and ndots option of the resolv.conf.
+func (c *resolvConf) nameList(name string) []string {
+	cc := &dns.ClientConfig{Search: c.search, Ndots: c.ndots}
+	return cc.NameList(name)
+}
+
+// continuesSearch checks if the response makes a stub resolver move on to
+// the next name of the search list: the name doesn't exist, has no records
+// or the servers failed.
+func continuesSearch(response *dns.Msg) bool {
+	switch response.Rcode {
+	case dns.RcodeNameError, dns.RcodeServerFailure:
+		return true
+	case dns.RcodeSuccess:
+		return len(response.Answer) == 0
+	}
+	return false
+}
+
+// search expands the domain of the request with the search list like the
+// libc resolver does, querying each name in turn until one of them has
+// records. Every query made along the way is recorded in the stream, and
+// the RTT returned is the sum of their RTTs, which is the time applications
+// wait for the answer. When no name has records, the response for the last
+// name is returned.
+func (d *dnsStream) search(dnsClient dnsClientInterface, conf *resolvConf) (string, *dns.Msg, time.Duration, error) {
+	d.searchQueries = d.searchQueries[:0]
+	names := conf.nameList(d.request.domain)
+
+	var total time.Duration
+	for i, name := range names {
+		query := d.constructQuery()
+		query.Question[0].Name = name
+
+		server, response, rtt, err := d.exchangeWithFailover(dnsClient, query, conf)
+		if err != nil {
+			d.searchQueries = append(d.searchQueries, searchQuery{name: name, rcode: searchQueryError})
+			return server, nil, total, err
+		}
+		total += rtt
+		d.searchQueries = append(d.searchQueries, searchQuery{name: name, rtt: rtt, rcode: dns.RcodeToString[response.Rcode]})
+
+		if i == len(names)-1 || !continuesSearch(response) {
+			return server, response, total, nil
+		}
+		log.Debugf("Search for domain:<%s> and DNS query type:<%s> got %s for name:<%s>, trying the next name",
+			d.request.domain, d.request.queryType, dns.RcodeToString[response.Rcode], name)
+	}
+
+	// The name list always has at least one name
+	return "", nil, total, nil
+}
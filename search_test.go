@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const kubernetesResolvConf = "nameserver 10.0.0.10\nsearch default.svc.cluster.local svc.cluster.local cluster.local\noptions ndots:5\n"
+
+func TestNameList(t *testing.T) {
+	t.Parallel()
+	conf, err := parseResolvConf([]byte(kubernetesResolvConf))
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		name     string
+		expected []string
+	}{
+		"Short name": {"my-svc", []string{
+			"my-svc.default.svc.cluster.local.", "my-svc.svc.cluster.local.", "my-svc.cluster.local.", "my-svc.",
+		}},
+		"Less dots than ndots": {"thebeat.co", []string{
+			"thebeat.co.default.svc.cluster.local.", "thebeat.co.svc.cluster.local.", "thebeat.co.cluster.local.", "thebeat.co.",
+		}},
+		"As many dots as ndots": {"a.b.c.d.e.f", []string{
+			"a.b.c.d.e.f.", "a.b.c.d.e.f.default.svc.cluster.local.", "a.b.c.d.e.f.svc.cluster.local.", "a.b.c.d.e.f.cluster.local.",
+		}},
+		"Fully qualified": {"thebeat.co.", []string{"thebeat.co."}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, conf.nameList(tt.name))
+		})
+	}
+}
+
+// searchClientTest answers the names it knows with an A record, NODATA for
+// the names mapped to nil and NXDOMAIN for every other name.
+type searchClientTest struct {
+	records map[string][]string
+	queried []string
+}
+
+func (c *searchClientTest) query(q *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	name := q.Question[0].Name
+	c.queried = append(c.queried, name)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	ips, ok := c.records[name]
+	if !ok {
+		m.Rcode = dns.RcodeNameError
+	}
+	for _, ip := range ips {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP(ip),
+		})
+	}
+	return m, 10 * time.Millisecond, nil
+}
+
+func TestQuerySearchList(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		domain   string
+		records  map[string][]string
+		queries  []searchQuery
+		answers  []string
+		expected rCode
+	}{
+		"Found in the first search domain": {
+			domain:  "my-svc",
+			records: map[string][]string{"my-svc.default.svc.cluster.local.": {"10.1.0.1"}},
+			queries: []searchQuery{
+				{name: "my-svc.default.svc.cluster.local.", rtt: 10 * time.Millisecond, rcode: "NOERROR"},
+			},
+			answers:  []string{"10.1.0.1"},
+			expected: NOERROR,
+		},
+		"External name after the search domains": {
+			domain:  "thebeat.co",
+			records: map[string][]string{"thebeat.co.svc.cluster.local.": nil, "thebeat.co.": {"1.1.1.1"}},
+			queries: []searchQuery{
+				{name: "thebeat.co.default.svc.cluster.local.", rtt: 10 * time.Millisecond, rcode: "NXDOMAIN"},
+				{name: "thebeat.co.svc.cluster.local.", rtt: 10 * time.Millisecond, rcode: "NOERROR"},
+				{name: "thebeat.co.cluster.local.", rtt: 10 * time.Millisecond, rcode: "NXDOMAIN"},
+				{name: "thebeat.co.", rtt: 10 * time.Millisecond, rcode: "NOERROR"},
+			},
+			answers:  []string{"1.1.1.1"},
+			expected: NOERROR,
+		},
+		"Not found": {
+			domain:  "missing",
+			records: map[string][]string{},
+			queries: []searchQuery{
+				{name: "missing.default.svc.cluster.local.", rtt: 10 * time.Millisecond, rcode: "NXDOMAIN"},
+				{name: "missing.svc.cluster.local.", rtt: 10 * time.Millisecond, rcode: "NXDOMAIN"},
+				{name: "missing.cluster.local.", rtt: 10 * time.Millisecond, rcode: "NXDOMAIN"},
+				{name: "missing.", rtt: 10 * time.Millisecond, rcode: "NXDOMAIN"},
+			},
+			expected: NXDOMAIN,
+		},
+		"Fully qualified": {
+			domain:  "thebeat.co.",
+			records: map[string][]string{"thebeat.co.": {"1.1.1.1"}},
+			queries: []searchQuery{
+				{name: "thebeat.co.", rtt: 10 * time.Millisecond, rcode: "NOERROR"},
+			},
+			answers:  []string{"1.1.1.1"},
+			expected: NOERROR,
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			path := filepath.Join(t.TempDir(), "resolv.conf")
+			writeResolvConf(t, path, kubernetesResolvConf)
+			s := newDNSStream(&dnsRequest{domain: tt.domain, queryType: "A", useSearchList: true}, 100)
+			s.systemResolver = newSystemResolver(path)
+			c := &searchClientTest{records: tt.records}
+
+			require.NoError(t, s.query(c))
+			assert.Equal(t, tt.queries, s.searchQueries)
+			assert.Equal(t, tt.expected, s.response.code)
+			assert.Equal(t, tt.answers, s.response.answers)
+			assert.Equal(t, time.Duration(len(tt.queries))*10*time.Millisecond, s.rtt)
+		})
+	}
+}
+
+func TestQuerySearchListWithResolver(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	writeResolvConf(t, path, kubernetesResolvConf)
+	resolver := "10.0.0.53"
+	s := newDNSStream(&dnsRequest{domain: "my-svc", queryType: "A", resolver: &resolver, useSearchList: true}, 100)
+	s.systemResolver = newSystemResolver(path)
+	c := &searchClientTest{records: map[string][]string{"my-svc.svc.cluster.local.": {"10.1.0.1"}}}
+
+	require.NoError(t, s.query(c))
+	assert.Equal(t, "10.0.0.53:53", s.server)
+	assert.Len(t, s.searchQueries, 2)
+	assert.Equal(t, []string{"10.1.0.1"}, s.response.answers)
+
+	// Without the search list the name is queried as it is
+	s = newDNSStream(&dnsRequest{domain: "my-svc", queryType: "A", resolver: &resolver}, 100)
+	c.queried = nil
+	require.NoError(t, s.query(c))
+	assert.Equal(t, []string{"my-svc."}, c.queried)
+	assert.Empty(t, s.searchQueries)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// netResolverMode selects the implementation of the Go resolver used by
+// the system transport.
+type netResolverMode string
+
+const (
+	// netResolverAuto lets Go pick the implementation, like applications
+	// using the default resolver do.
+	netResolverAuto netResolverMode = "auto"
+	// netResolverGo uses the resolver written in Go.
+	netResolverGo netResolverMode = "go"
+	// netResolverCgo uses the libc resolver. Go only lets the whole process
+	// pick it, so every lookup that doesn't force the resolver written in
+	// Go uses it too.
+	netResolverCgo netResolverMode = "cgo"
+)
+
+// newNetResolverMode returns the resolver implementation of the given name,
+// falling back to auto when the name is empty. The cgo implementation is
+// only available in binaries built with cgo.
+func newNetResolverMode(name string) (netResolverMode, error) {
+	m := netResolverMode(strings.ToLower(name))
+	switch m {
+	case "":
+		return netResolverAuto, nil
+	case netResolverAuto, netResolverGo:
+		return m, nil
+	case netResolverCgo:
+		if !cgoAvailable {
+			return "", errors.New("the cgo system resolver is not available, dns-verifier was built without cgo")
+		}
+		return m, nil
+	}
+	return "", errors.Errorf("%s is not a supported system resolver, use auto, go or cgo", name)
+}
+
+// useCgoResolver makes the process use the libc resolver for the lookups
+// that don't force the resolver written in Go, by setting netdns=cgo in
+// GODEBUG. Go reads the setting on the first lookup of the process, so this
+// needs to run before anything is looked up.
+func useCgoResolver() {
+	godebug := withGODEBUG(os.Getenv("GODEBUG"), "netdns", "cgo")
+	if err := os.Setenv("GODEBUG", godebug); err != nil {
+		log.Errorf("Cannot switch to the cgo system resolver: %v", err)
+	}
+}
+
+// withGODEBUG returns the GODEBUG settings with the given key set to the
+// value, replacing the value it had.
+func withGODEBUG(godebug, key, value string) string {
+	var settings []string
+	for _, s := range strings.Split(godebug, ",") {
+		if s == "" || strings.HasPrefix(s, key+"=") {
+			continue
+		}
+		settings = append(settings, s)
+	}
+	return strings.Join(append(settings, key+"="+value), ",")
+}
+
+// systemQueryTypes are the query types the system transport can look up.
+var systemQueryTypes = []uint16{
+	dns.TypeA, dns.TypeAAAA, dns.TypeCNAME, dns.TypeMX, dns.TypeNS, dns.TypePTR, dns.TypeSRV, dns.TypeTXT,
+}
+
+// systemClient looks domains up through the Go resolver, which reads
+// /etc/hosts and nsswitch.conf like the applications do, instead of
+// sending DNS queries itself. The results are turned into a DNS response,
+// so they are verified in the same way as the ones of the other transports.
+type systemClient struct {
+	resolver *net.Resolver
+	// domain is the domain of the request as configured, which is looked up
+	// as it is so the system resolver applies its search list to it.
+	domain  string
+	timeout time.Duration
+}
+
+func newSystemClient(r *dnsRequest) *systemClient {
+	return &systemClient{
+		resolver: &net.Resolver{PreferGo: r.netResolverMode == netResolverGo},
+		domain:   r.domain,
+		timeout:  r.queryTimeout(),
+	}
+}
+
+// query looks up the question of the query. The server is ignored, as the
+// system resolver decides which servers to ask. Names that are not found
+// are answered with NXDOMAIN and other resolver failures with SERVFAIL.
+func (c *systemClient) query(query *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	q := query.Question[0]
+	name := q.Name
+	if name == dns.Fqdn(c.domain) {
+		name = c.domain
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	start := time.Now()
+	answers, err := c.lookup(ctx, name, q)
+	rtt := time.Since(start)
+
+	response := new(dns.Msg)
+	response.SetReply(query)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || dnsErr.IsTimeout {
+			return nil, rtt, errors.Wrapf(err, "System lookup of %s failed", name)
+		}
+		response.Rcode = dns.RcodeServerFailure
+		if dnsErr.IsNotFound {
+			response.Rcode = dns.RcodeNameError
+		}
+		return response, rtt, nil
+	}
+	response.Answer = answers
+	return response, rtt, nil
+}
+
+// lookup looks up the records of the name and returns them as records of
+// the question.
+func (c *systemClient) lookup(ctx context.Context, name string, q dns.Question) ([]dns.RR, error) {
+	hdr := func() dns.RR_Header {
+		return dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET}
+	}
+
+	var answers []dns.RR
+	switch q.Qtype {
+	case dns.TypeA, dns.TypeAAAA:
+		network := "ip4"
+		if q.Qtype == dns.TypeAAAA {
+			network = "ip6"
+		}
+		ips, err := c.resolver.LookupNetIP(ctx, network, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if q.Qtype == dns.TypeA {
+				answers = append(answers, &dns.A{Hdr: hdr(), A: ip.Unmap().AsSlice()})
+			} else {
+				answers = append(answers, &dns.AAAA{Hdr: hdr(), AAAA: ip.AsSlice()})
+			}
+		}
+	case dns.TypeCNAME:
+		cname, err := c.resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		// Names without a CNAME record are their own canonical name
+		if !strings.EqualFold(dns.Fqdn(cname), q.Name) {
+			answers = append(answers, &dns.CNAME{Hdr: hdr(), Target: dns.Fqdn(cname)})
+		}
+	case dns.TypeMX:
+		mxs, err := c.resolver.LookupMX(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, mx := range mxs {
+			answers = append(answers, &dns.MX{Hdr: hdr(), Preference: mx.Pref, Mx: dns.Fqdn(mx.Host)})
+		}
+	case dns.TypeNS:
+		nss, err := c.resolver.LookupNS(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, ns := range nss {
+			answers = append(answers, &dns.NS{Hdr: hdr(), Ns: dns.Fqdn(ns.Host)})
+		}
+	case dns.TypePTR:
+		ip, err := reverseAddr(name)
+		if err != nil {
+			return nil, err
+		}
+		names, err := c.resolver.LookupAddr(ctx, ip.String())
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range names {
+			answers = append(answers, &dns.PTR{Hdr: hdr(), Ptr: dns.Fqdn(n)})
+		}
+	case dns.TypeSRV:
+		_, srvs, err := c.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, srv := range srvs {
+			answers = append(answers, &dns.SRV{Hdr: hdr(), Priority: srv.Priority, Weight: srv.Weight, Port: srv.Port, Target: dns.Fqdn(srv.Target)})
+		}
+	case dns.TypeTXT:
+		txts, err := c.resolver.LookupTXT(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, txt := range txts {
+			answers = append(answers, &dns.TXT{Hdr: hdr(), Txt: []string{txt}})
+		}
+	default:
+		return nil, errors.Errorf("%s records cannot be looked up with the system transport", dns.TypeToString[q.Qtype])
+	}
+
+	return answers, nil
+}
+
+// reverseAddr returns the IP address of a reverse lookup, given either as
+// the address itself or as its name under in-addr.arpa or ip6.arpa.
+func reverseAddr(name string) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(name); err == nil {
+		return ip, nil
+	}
+
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	switch {
+	case len(labels) == 6 && strings.HasSuffix(dns.Fqdn(strings.ToLower(name)), ".in-addr.arpa."):
+		octets := slices.Clone(labels[:4])
+		slices.Reverse(octets)
+		if ip, err := netip.ParseAddr(strings.Join(octets, ".")); err == nil && ip.Is4() {
+			return ip, nil
+		}
+	case len(labels) == 34 && strings.HasSuffix(dns.Fqdn(strings.ToLower(name)), ".ip6.arpa."):
+		nibbles := slices.Clone(labels[:32])
+		slices.Reverse(nibbles)
+		if slices.ContainsFunc(nibbles, func(n string) bool { return len(n) != 1 }) {
+			break
+		}
+		var b strings.Builder
+		for i, n := range nibbles {
+			if i > 0 && i%4 == 0 {
+				b.WriteByte(':')
+			}
+			b.WriteString(n)
+		}
+		if ip, err := netip.ParseAddr(b.String()); err == nil && ip.Is6() {
+			return ip, nil
+		}
+	}
+	return netip.Addr{}, errors.Errorf("%s is neither an IP address nor a reverse lookup name", name)
+}
+
+// close releases nothing, the system resolver keeps no connections.
+func (c *systemClient) close() {}
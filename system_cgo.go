@@ -0,0 +1,7 @@
+//go:build cgo
+
+package main
+
+// cgoAvailable is set when the binary is built with cgo, so the system
+// transport can use the libc resolver.
+const cgoAvailable = true
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// systemHandler answers A, MX, TXT and SRV questions for thebeat.co and
+// NXDOMAIN for every other name.
+func systemHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	q := r.Question[0]
+	if q.Name != "thebeat.co." {
+		m.Rcode = dns.RcodeNameError
+		_ = w.WriteMsg(m)
+		return
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+	switch q.Qtype {
+	case dns.TypeA:
+		m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("127.0.0.1")})
+	case dns.TypeMX:
+		m.Answer = append(m.Answer, &dns.MX{Hdr: hdr, Preference: 10, Mx: "mx.thebeat.co."})
+	case dns.TypeTXT:
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: hdr, Txt: []string{"v=spf1 ", "-all"}})
+	case dns.TypeSRV:
+		m.Answer = append(m.Answer, &dns.SRV{Hdr: hdr, Priority: 10, Weight: 60, Port: 5060, Target: "sip.thebeat.co."})
+	}
+	_ = w.WriteMsg(m)
+}
+
+// newTestSystemClient returns a system client that uses the Go resolver
+// with the given server.
+func newTestSystemClient(domain, addr string) *systemClient {
+	return &systemClient{
+		resolver: &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}},
+		domain:  domain,
+		timeout: DefaultTimeout,
+	}
+}
+
+func TestSystemClientQuery(t *testing.T) {
+	t.Parallel()
+	addr := startUDPAndTCPServer(t, systemHandler)
+	tests := map[string]struct {
+		domain   string
+		qtype    string
+		rcode    rCode
+		expected []string
+	}{
+		"A":        {"thebeat.co", "A", NOERROR, []string{"127.0.0.1"}},
+		"MX":       {"thebeat.co", "MX", NOERROR, []string{"mx.thebeat.co."}},
+		"TXT":      {"thebeat.co", "TXT", NOERROR, []string{"v=spf1 -all"}},
+		"SRV":      {"thebeat.co", "SRV", NOERROR, []string{"10 60 5060 sip.thebeat.co."}},
+		"NXDOMAIN": {"missing.thebeat.co", "A", NXDOMAIN, nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			dr := &dnsRequest{domain: tt.domain, queryType: tt.qtype, transport: transportSystem}
+			s := newDNSStream(dr, 100)
+			require.NoError(t, s.query(newTestSystemClient(tt.domain, addr)))
+			assert.Equal(t, tt.rcode, s.response.code)
+			assert.Equal(t, tt.expected, s.response.answers)
+			assert.Equal(t, systemResolverLabel, s.server)
+		})
+	}
+}
+
+func TestSystemClientHosts(t *testing.T) {
+	t.Parallel()
+	dr := &dnsRequest{domain: "localhost", queryType: "A", transport: transportSystem, netResolverMode: netResolverGo}
+	s := newDNSStream(dr, 100)
+	if err := s.query(newSystemClient(dr)); err != nil {
+		t.Skipf("Cannot look up localhost: %v", err)
+	}
+	assert.Equal(t, []string{"127.0.0.1"}, s.response.answers)
+	assert.Equal(t, "system", s.labels()["transport"])
+}
+
+func TestReverseAddr(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		"IPv4":          {"10.0.0.1", "10.0.0.1", false},
+		"IPv6":          {"2001:db8::1", "2001:db8::1", false},
+		"in-addr.arpa":  {"1.0.0.10.in-addr.arpa.", "10.0.0.1", false},
+		"ip6.arpa":      {"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa", "2001:db8::1", false},
+		"Short arpa":    {"0.10.in-addr.arpa.", "", true},
+		"Invalid octet": {"1.0.0.300.in-addr.arpa.", "", true},
+		"Domain":        {"thebeat.co", "", true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ip, err := reverseAddr(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, netip.MustParseAddr(tt.expected), ip)
+		})
+	}
+}
+
+func TestNewNetResolverMode(t *testing.T) {
+	t.Parallel()
+	m, err := newNetResolverMode("")
+	require.NoError(t, err)
+	assert.Equal(t, netResolverAuto, m)
+
+	m, err = newNetResolverMode("Go")
+	require.NoError(t, err)
+	assert.Equal(t, netResolverGo, m)
+
+	_, err = newNetResolverMode("cgo")
+	assert.Equal(t, cgoAvailable, err == nil)
+
+	_, err = newNetResolverMode("libc")
+	require.Error(t, err)
+}
+
+func TestWithGODEBUG(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		godebug  string
+		expected string
+	}{
+		"Empty":              {"", "netdns=cgo"},
+		"Other settings":     {"http2client=0", "http2client=0,netdns=cgo"},
+		"Replaced setting":   {"netdns=go,http2client=0", "http2client=0,netdns=cgo"},
+		"Setting with debug": {"netdns=go+2", "netdns=cgo"},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.expected, withGODEBUG(tt.godebug, "netdns", "cgo"))
+		})
+	}
+}
+
+func TestUsesCgoResolver(t *testing.T) {
+	t.Parallel()
+	system, udp, cgo, goResolver := "System", "udp", "CGO", "go"
+	assert.True(t, (&YamlRequest{Transport: &system, SystemResolver: &cgo}).usesCgoResolver())
+	assert.False(t, (&YamlRequest{Transport: &system, SystemResolver: &goResolver}).usesCgoResolver())
+	assert.False(t, (&YamlRequest{Transport: &system}).usesCgoResolver())
+	assert.False(t, (&YamlRequest{Transport: &udp, SystemResolver: &cgo}).usesCgoResolver())
+}
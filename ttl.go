@@ -0,0 +1,116 @@
+package main
+
+import (
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ttlCountdownSlack is how many seconds a TTL can be off from the one
+// counted down since the previous query, as TTLs are rounded down to whole
+// seconds.
+const ttlCountdownSlack = 1
+
+// ttlAnomaly is how the TTL of an answer cached by a resolver failed to
+// count down between two queries.
+type ttlAnomaly string
+
+const (
+	// ttlStuck means the TTL didn't decrease, so the resolver isn't caching
+	// the answer.
+	ttlStuck ttlAnomaly = "stuck"
+	// ttlReset means the TTL went up before the cached answer expired, so
+	// the resolver flaps between upstreams or caches.
+	ttlReset ttlAnomaly = "reset"
+)
+
+// ttlSample is the TTL of an answer and when it was seen.
+type ttlSample struct {
+	ttl uint32
+	at  time.Time
+}
+
+// answerTTLs returns the TTLs of the answers of the given type of a DNS
+// response, in the same order as the answers parseRawResponse returns.
+func answerTTLs(rawResponse *dns.Msg, qtype uint16) []uint32 {
+	if rCode(rawResponse.Rcode) != NOERROR {
+		return nil
+	}
+	var ttls []uint32
+	for _, answer := range rawResponse.Answer {
+		if isAnswerOf(answer, qtype) {
+			ttls = append(ttls, answer.Header().Ttl)
+		}
+	}
+	return ttls
+}
+
+// newTTLRange validates the range the TTLs of the answers should fall in.
+func newTTLRange(minTTL, maxTTL *int) error {
+	if minTTL != nil && *minTTL < 0 {
+		return errors.Errorf("minTTL cannot be negative, got %d", *minTTL)
+	}
+	if maxTTL != nil && *maxTTL < 0 {
+		return errors.Errorf("maxTTL cannot be negative, got %d", *maxTTL)
+	}
+	if minTTL != nil && maxTTL != nil && *minTTL > *maxTTL {
+		return errors.Errorf("minTTL(%d) cannot be greater than maxTTL(%d)", *minTTL, *maxTTL)
+	}
+	return nil
+}
+
+// verifyTTLs records a failure for every answer whose TTL falls out of the
+// range of the request.
+func (d *dnsStream) verifyTTLs() {
+	for i, ttl := range d.response.ttls {
+		if d.request.minTTL != nil && int(ttl) < *d.request.minTTL {
+			d.fail("TTL:<%d> of answer:<%s> for quering domain:<%s> and DNS query type:<%s> is below minTTL:<%d>",
+				ttl, d.response.answers[i], d.request.domain, d.request.queryType, *d.request.minTTL)
+		}
+		if d.request.maxTTL != nil && int(ttl) > *d.request.maxTTL {
+			d.fail("TTL:<%d> of answer:<%s> for quering domain:<%s> and DNS query type:<%s> is above maxTTL:<%d>",
+				ttl, d.response.answers[i], d.request.domain, d.request.queryType, *d.request.maxTTL)
+		}
+	}
+}
+
+// trackTTLCountdown compares the TTL of every answer with the one the same
+// server gave in the previous query, counted down by the time passed since,
+// and records the answers whose TTL didn't count down like a cache does.
+// A TTL that went up after the cached answer expired is the resolver
+// fetching the answer again, so it isn't an anomaly.
+func (d *dnsStream) trackTTLCountdown(now time.Time) {
+	d.ttlAnomalies = nil
+	samples := make(map[string]ttlSample, len(d.response.answers))
+	for i, answer := range d.response.answers {
+		key := d.server + " " + answer
+		ttl := d.response.ttls[i]
+		samples[key] = ttlSample{ttl: ttl, at: now}
+
+		prev, ok := d.ttlSamples[key]
+		if !ok {
+			continue
+		}
+		elapsed := now.Sub(prev.at)
+		remaining := int64(prev.ttl) - int64(elapsed/time.Second)
+		if remaining <= ttlCountdownSlack {
+			continue
+		}
+
+		var anomaly ttlAnomaly
+		switch {
+		case ttl == prev.ttl && elapsed >= (ttlCountdownSlack+1)*time.Second:
+			anomaly = ttlStuck
+		case int64(ttl) > remaining+ttlCountdownSlack:
+			anomaly = ttlReset
+		default:
+			continue
+		}
+		log.Infof("TTL of answer:<%s> for quering domain:<%s> and DNS query type:<%s> from server:<%s> is %s, went from %d to %d in %s",
+			answer, d.request.domain, d.request.queryType, d.server, anomaly, prev.ttl, ttl, elapsed.Round(time.Second))
+		d.ttlAnomalies = append(d.ttlAnomalies, anomaly)
+	}
+	d.ttlSamples = samples
+}
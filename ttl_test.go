@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTTLStream returns a stream with a response of A records with the
+// given TTLs, one per address of 127.0.0.0/24.
+func newTestTTLStream(ttls ...uint32) *dnsStream {
+	s := newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A"}, 100)
+	s.server = "1.1.1.1:53"
+	s.response.rawResponse = testTTLResponse(ttls...)
+	s.parseResponse()
+	return s
+}
+
+func testTTLResponse(ttls ...uint32) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion("thebeat.co.", dns.TypeA)
+	for i, ttl := range ttls {
+		m.Answer = append(m.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "thebeat.co.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   net.IPv4(127, 0, 0, byte(i+1)),
+		})
+	}
+	return m
+}
+
+func TestAnswerTTLs(t *testing.T) {
+	t.Parallel()
+	m := testTTLResponse(300, 60)
+	cname := &dns.CNAME{Hdr: dns.RR_Header{Name: "www.thebeat.co.", Rrtype: dns.TypeCNAME, Ttl: 3600}, Target: "thebeat.co."}
+	m.Answer = append([]dns.RR{cname}, m.Answer...)
+	assert.Equal(t, []uint32{300, 60}, answerTTLs(m, dns.TypeA))
+	assert.Equal(t, []uint32{3600}, answerTTLs(m, dns.TypeCNAME))
+	assert.Equal(t, []uint32{3600, 300, 60}, answerTTLs(m, dns.TypeANY))
+
+	m.Rcode = dns.RcodeServerFailure
+	assert.Empty(t, answerTTLs(m, dns.TypeA))
+}
+
+func TestNewTTLRange(t *testing.T) {
+	t.Parallel()
+	zero, minute, week, negative := 0, 60, 604800, -1
+	tests := map[string]struct {
+		minTTL, maxTTL *int
+		wantErr        bool
+	}{
+		"No range":         {nil, nil, false},
+		"Only minimum":     {&minute, nil, false},
+		"Only maximum":     {nil, &week, false},
+		"Zero minimum":     {&zero, &minute, false},
+		"Same bounds":      {&minute, &minute, false},
+		"Negative minimum": {&negative, nil, true},
+		"Negative maximum": {nil, &negative, true},
+		"Inverted range":   {&week, &minute, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			err := newTTLRange(tt.minTTL, tt.maxTTL)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestVerifyTTLs(t *testing.T) {
+	t.Parallel()
+	minute, day := 60, 86400
+	tests := map[string]struct {
+		ttls           []uint32
+		minTTL, maxTTL *int
+		legit          bool
+	}{
+		"No range":             {[]uint32{0, 604800}, nil, nil, true},
+		"Within range":         {[]uint32{60, 300}, &minute, &day, true},
+		"Zero TTL":             {[]uint32{300, 0}, &minute, nil, false},
+		"Week TTL":             {[]uint32{604800}, nil, &day, false},
+		"No answers":           {nil, &minute, &day, true},
+		"On the maximum bound": {[]uint32{86400}, &minute, &day, true},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := newTestTTLStream(tt.ttls...)
+			s.request.minTTL, s.request.maxTTL = tt.minTTL, tt.maxTTL
+			assert.Equal(t, tt.legit, s.isResponseLegit())
+			if !tt.legit {
+				assert.Contains(t, s.failure(), "TTL")
+			}
+		})
+	}
+}
+
+func TestTrackTTLCountdown(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]struct {
+		first, second uint32
+		elapsed       time.Duration
+		server        string
+		expected      []ttlAnomaly
+	}{
+		"Counting down":           {300, 270, 30 * time.Second, "", nil},
+		"Rounded down":            {300, 269, 30 * time.Second, "", nil},
+		"Same second":             {300, 300, 500 * time.Millisecond, "", nil},
+		"Stuck":                   {300, 300, 30 * time.Second, "", []ttlAnomaly{ttlStuck}},
+		"Stuck for two seconds":   {300, 300, 2 * time.Second, "", []ttlAnomaly{ttlStuck}},
+		"Reset to a higher TTL":   {100, 300, 30 * time.Second, "", []ttlAnomaly{ttlReset}},
+		"Reset before expiring":   {300, 290, 30 * time.Second, "", []ttlAnomaly{ttlReset}},
+		"Fetched after expiring":  {30, 300, 30 * time.Second, "", nil},
+		"Fetched at expiring":     {31, 300, 30 * time.Second, "", nil},
+		"Another server answered": {300, 300, 30 * time.Second, "8.8.8.8:53", nil},
+		"Uncached answer":         {0, 0, 30 * time.Second, "", nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			s := newTestTTLStream(tt.first)
+			s.trackTTLCountdown(now)
+			assert.Empty(t, s.ttlAnomalies)
+
+			s.response.rawResponse = testTTLResponse(tt.second)
+			s.parseResponse()
+			if tt.server != "" {
+				s.server = tt.server
+			}
+			s.trackTTLCountdown(now.Add(tt.elapsed))
+			assert.Equal(t, tt.expected, s.ttlAnomalies)
+		})
+	}
+}
+
+func TestTrackTTLCountdownForgetsAnswers(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := newTestTTLStream(300, 300)
+	s.trackTTLCountdown(now)
+	require.Len(t, s.ttlSamples, 2)
+
+	// The second answer is gone, so it isn't compared once it's back
+	s.response.rawResponse = testTTLResponse(270)
+	s.parseResponse()
+	s.trackTTLCountdown(now.Add(30 * time.Second))
+	assert.Len(t, s.ttlSamples, 1)
+
+	s.response.rawResponse = testTTLResponse(240, 300)
+	s.parseResponse()
+	s.trackTTLCountdown(now.Add(60 * time.Second))
+	assert.Empty(t, s.ttlAnomalies)
+}
+
+func TestGetCleanRequestTTL(t *testing.T) {
+	t.Parallel()
+	minute, week, system := 60, 604800, "system"
+
+	r := &YamlRequest{Domain: "thebeat.co", MinTTL: &minute, MaxTTL: &week}
+	s, err := r.getCleanRequest()
+	require.NoError(t, err)
+	assert.Equal(t, &minute, s.request.minTTL)
+	assert.Equal(t, &week, s.request.maxTTL)
+
+	r = &YamlRequest{Domain: "thebeat.co", MinTTL: &week, MaxTTL: &minute}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+
+	r = &YamlRequest{Domain: "thebeat.co", Transport: &system, MinTTL: &minute}
+	_, err = r.getCleanRequest()
+	require.Error(t, err)
+}
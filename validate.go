@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// validationReport is the outcome of validating a config file.
+type validationReport struct {
+	Config   string              `json:"config"`
+	Valid    bool                `json:"valid"`
+	Error    string              `json:"error,omitempty"`
+	Requests []requestValidation `json:"requests"`
+}
+
+// requestValidation is the outcome of validating a request of the config.
+type requestValidation struct {
+	Index  int    `json:"index"`
+	Domain string `json:"domain"`
+	Valid  bool   `json:"valid"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runValidate loads the config and cleans every request of it like the
+// watchdog does, reporting every invalid request instead of skipping it.
+// It exits with exitFailure when the config or any of its requests is
+// invalid.
+func runValidate(args []string, stdout, stderr io.Writer) int {
+	flags := pflag.NewFlagSet("validate", pflag.ContinueOnError)
+	flags.SetOutput(stderr)
+	path := flags.StringP("config", "c", "", "path of the config file, by default config.yaml in the current directory or /etc/dns-verifier")
+	output := flags.StringP("output", "o", "text", "format of the report, text or json")
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "error:%s is not a supported output, use text or json\n", *output)
+		return exitUsage
+	}
+
+	initCommandLogging(stderr)
+	report := &validationReport{Config: *path, Requests: []requestValidation{}}
+	r, err := loadYamlConfig(*path)
+	if report.Config == "" {
+		report.Config = viper.ConfigFileUsed()
+	}
+	if err != nil {
+		report.Error = err.Error()
+	} else {
+		report.validate(r)
+	}
+
+	if err := report.write(stdout, *output); err != nil {
+		fmt.Fprintf(stderr, "error:%v\n", err)
+		return exitError
+	}
+	if !report.Valid {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// validate cleans every request of the config and records the outcome.
+func (v *validationReport) validate(r *YamlRequests) {
+	v.Requests = make([]requestValidation, 0, len(r.Requests))
+	for i, req := range r.Requests {
+		v.Requests = append(v.Requests, requestValidation{Index: i, Domain: req.Domain, Valid: true})
+	}
+	_, requestErrors := r.cleanRequests()
+	for _, e := range requestErrors {
+		v.Requests[e.index].Valid = false
+		v.Requests[e.index].Error = e.err.Error()
+	}
+
+	switch {
+	case len(r.Requests) == 0:
+		v.Error = "the config has no requests"
+	case len(requestErrors) > 0:
+		v.Error = fmt.Sprintf("%d of %d requests are invalid", len(requestErrors), len(r.Requests))
+	}
+	v.Valid = v.Error == ""
+}
+
+// write writes the report in the given format, either text or json.
+func (v *validationReport) write(w io.Writer, output string) error {
+	if output == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.Wrap(enc.Encode(v), "Cannot write the report")
+	}
+
+	for _, r := range v.Requests {
+		if !r.Valid {
+			fmt.Fprintf(w, "requests[%d] (domain:<%s>): %s\n", r.Index, r.Domain, r.Error)
+		}
+	}
+	if v.Valid {
+		_, err := fmt.Fprintf(w, "%s: all %d requests are valid\n", v.Config, len(v.Requests))
+		return errors.Wrap(err, "Cannot write the report")
+	}
+	_, err := fmt.Fprintf(w, "%s: %s\n", v.Config, v.Error)
+	return errors.Wrap(err, "Cannot write the report")
+}
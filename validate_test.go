@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationReportValidate(t *testing.T) {
+	t.Parallel()
+	invalid := "FOO"
+	tests := map[string]struct {
+		requests []YamlRequest
+		valid    []bool
+		wantErr  string
+	}{
+		"All valid": {
+			requests: []YamlRequest{{Domain: "thebeat.co"}, {Domain: "thebeat.co", QueryType: "NS"}},
+			valid:    []bool{true, true},
+		},
+		"Invalid requests": {
+			requests: []YamlRequest{{Domain: "thebeat.co", QueryType: "FOO"}, {Domain: "thebeat.co"}, {Domain: "thebeat.co", ExpectedResponseCode: &invalid}},
+			valid:    []bool{false, true, false},
+			wantErr:  "2 of 3 requests are invalid",
+		},
+		"No requests": {
+			valid:   []bool{},
+			wantErr: "the config has no requests",
+		},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			v := &validationReport{Config: "config.yaml"}
+			v.validate(&YamlRequests{Requests: tt.requests})
+			assert.Equal(t, tt.wantErr == "", v.Valid)
+			assert.Equal(t, tt.wantErr, v.Error)
+			require.Len(t, v.Requests, len(tt.valid))
+			for i, valid := range tt.valid {
+				assert.Equal(t, valid, v.Requests[i].Valid, i)
+				assert.Equal(t, valid, v.Requests[i].Error == "", i)
+			}
+		})
+	}
+}
+
+func TestValidationReportWrite(t *testing.T) {
+	t.Parallel()
+	v := &validationReport{Config: "config.yaml"}
+	v.validate(&YamlRequests{Requests: []YamlRequest{{Domain: "thebeat.co"}, {Domain: "google.com", QueryType: "FOO"}}})
+
+	var text bytes.Buffer
+	require.NoError(t, v.write(&text, "text"))
+	assert.Equal(t, "requests[1] (domain:<google.com>): FOO is not a supported DNS query type\nconfig.yaml: 1 of 2 requests are invalid\n", text.String())
+
+	var report validationReport
+	var out bytes.Buffer
+	require.NoError(t, v.write(&out, "json"))
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, *v, report)
+
+	v = &validationReport{Config: "config.yaml"}
+	v.validate(&YamlRequests{Requests: []YamlRequest{{Domain: "thebeat.co"}}})
+	text.Reset()
+	require.NoError(t, v.write(&text, "text"))
+	assert.Equal(t, "config.yaml: all 1 requests are valid\n", text.String())
+}
+
+// TestRunValidate doesn't run in parallel, as it loads the config through
+// the global viper instance.
+func TestRunValidate(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	defer log.SetLevel(log.GetLevel())
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	require.NoError(t, os.WriteFile(valid, []byte("requests:\n  - domain: thebeat.co\n"), 0o600))
+	invalid := filepath.Join(dir, "invalid.yaml")
+	require.NoError(t, os.WriteFile(invalid, []byte("requests:\n  - domain: thebeat.co\n    queryType: FOO\n"), 0o600))
+	unknown := filepath.Join(dir, "unknown.yaml")
+	require.NoError(t, os.WriteFile(unknown, []byte("requests:\n  - domain: thebeat.co\n    resolve: 8.8.8.8\n"), 0o600))
+
+	tests := map[string]struct {
+		args     []string
+		exitCode int
+	}{
+		"Valid":          {[]string{"--config", valid}, exitOK},
+		"Invalid":        {[]string{"--config", invalid}, exitFailure},
+		"Unknown key":    {[]string{"-c", unknown}, exitFailure},
+		"Missing config": {[]string{"--config", filepath.Join(dir, "missing.yaml")}, exitFailure},
+		"Bad output":     {[]string{"--config", valid, "--output", "xml"}, exitUsage},
+		"Bad flag":       {[]string{"--foo"}, exitUsage},
+	}
+	for name, tt := range tests {
+		var stdout bytes.Buffer
+		assert.Equal(t, tt.exitCode, runValidate(tt.args, &stdout, io.Discard), name)
+	}
+
+	var stdout bytes.Buffer
+	require.Equal(t, exitFailure, runValidate([]string{"--config", unknown, "--output", "json"}, &stdout, io.Discard))
+	var report validationReport
+	require.NoError(t, json.Unmarshal(stdout.Bytes(), &report))
+	assert.Equal(t, unknown, report.Config)
+	assert.False(t, report.Valid)
+	assert.Contains(t, report.Error, `requests[0] (line 3): unknown key "resolve", did you mean "resolver"?`)
+}
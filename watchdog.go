@@ -2,53 +2,98 @@ package main
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// clock abstracts the passing of time, so the scheduling of the workers
+// can be tested without waiting.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock of the system.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
 type watchdogWorker struct {
 	dnsStream *dnsStream
 	exit      chan bool
 	stopped   bool
-	ticker    *time.Ticker
+	clock     clock
+	// delay is how long the worker waits before its first run, made of the
+	// global start offset and a random splay of up to the jitter of the
+	// stream, so workers with the same interval don't run in lockstep.
+	delay time.Duration
+	// newClient creates the client the worker sends its queries with.
+	newClient func(r *dnsRequest) resolverClient
 }
 
-func newWatchdogWorker(d *dnsStream) *watchdogWorker {
+func newWatchdogWorker(d *dnsStream, startOffset time.Duration, c clock) *watchdogWorker {
+	delay := startOffset
+	if d.jitter > 0 {
+		// The splay only spreads the load, it doesn't need a secure source
+		delay += time.Duration(rand.Int64N(int64(d.jitter))) //nolint:gosec
+	}
 	return &watchdogWorker{
 		dnsStream: d,
 		exit:      make(chan bool, 1),
-		ticker:    time.NewTicker(time.Duration(d.interval) * time.Second),
 		stopped:   true,
+		clock:     c,
+		delay:     delay,
+		newClient: newResolverClient,
 	}
 }
 
+// watch runs the checks of the worker, the first one right after its
+// delay and then one every interval.
 func (ww *watchdogWorker) watch() {
 	ww.stopped = false
-	dnsClient := newDNSClient()
+	dnsClient := ww.newClient(&ww.dnsStream.request)
+	defer dnsClient.close()
 
-	log.Infof("Entering watchdog's worker(%s) internal loop", ww)
+	interval := time.Duration(ww.dnsStream.interval) * time.Second
+	next := ww.clock.Now().Add(ww.delay)
+	log.Infof("Entering watchdog's worker(%s) internal loop, first check in %s", ww, ww.delay)
 	for {
 		select {
 		case <-ww.exit:
 			log.Infof("Got message in watchdog's worker(%s) exit channel, exiting watchdog's loop", ww)
 			return
-		case <-ww.ticker.C:
-			log.Debugf("Starting new watchdog's worker(%s) interval check", ww)
-			log.Debugf("Start query for domain:<%s> and DNS query type:<%s>", ww.dnsStream.request.domain, ww.dnsStream.request.queryType)
-			err := ww.dnsStream.query(dnsClient)
-			if err != nil {
-				log.Error(err)
+		case <-ww.clock.After(next.Sub(ww.clock.Now())):
+			ww.check(dnsClient)
+
+			// Checks that outlast the interval skip the runs they missed,
+			// instead of running back to back to catch up
+			next = next.Add(interval)
+			for now := ww.clock.Now(); !next.After(now); {
+				next = next.Add(interval)
 			}
-			log.Debugf("Finished query for domain:<%s> and DNS query type:<%s> with verification status:<%.f>", ww.dnsStream.request.domain, ww.dnsStream.request.queryType, ww.dnsStream.verificationStatus)
-
-			ww.dnsStream.updateStats()
-
-			log.Debugf("Finished watchdog's worker(%s) interval check", ww)
 		}
 	}
 }
 
+// check queries the domain of the worker and updates its stats.
+func (ww *watchdogWorker) check(dnsClient dnsClientInterface) {
+	log.Debugf("Starting new watchdog's worker(%s) interval check", ww)
+	log.Debugf("Start query for domain:<%s> and DNS query type:<%s>", ww.dnsStream.request.domain, ww.dnsStream.request.queryType)
+	err := ww.dnsStream.query(dnsClient)
+	if err != nil {
+		log.Error(err)
+	}
+	log.Debugf("Finished query for domain:<%s> and DNS query type:<%s> with verification status:<%.f>", ww.dnsStream.request.domain, ww.dnsStream.request.queryType, ww.dnsStream.verificationStatus)
+
+	ww.dnsStream.updateStats()
+
+	log.Debugf("Finished watchdog's worker(%s) interval check", ww)
+}
+
 func (ww *watchdogWorker) stop() {
 	if ww.stopped {
 		log.Infof("Watchdog's worker(%s) already stopped", ww)
@@ -61,7 +106,7 @@ func (ww *watchdogWorker) stop() {
 }
 
 func (ww *watchdogWorker) String() string {
-	return fmt.Sprintf("Domain:<%s> - Query Type:<%s> - interval:<%d>", ww.dnsStream.request.domain, ww.dnsStream.request.queryType, ww.dnsStream.interval)
+	return fmt.Sprintf("Domain:<%s> - Query Type:<%s> - Resolver:<%s> - interval:<%d>", ww.dnsStream.request.domain, ww.dnsStream.request.queryType, ww.dnsStream.resolverLabel(), ww.dnsStream.interval)
 }
 
 type watchdog struct {
@@ -69,10 +114,10 @@ type watchdog struct {
 	workers []*watchdogWorker
 }
 
-func newWatchdog(requests []*dnsStream) *watchdog {
+func newWatchdog(requests []*dnsStream, startOffset time.Duration) *watchdog {
 	workers := []*watchdogWorker{}
 	for _, r := range requests {
-		w := newWatchdogWorker(r)
+		w := newWatchdogWorker(r, startOffset, realClock{})
 		workers = append(workers, w)
 	}
 
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock that only moves when advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeClockWaiter
+}
+
+type fakeClockWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeClockWaiter{deadline: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// advance moves the clock forward, firing the waiters whose time has come.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.deadline.After(c.now) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.ch <- c.now
+	}
+	c.waiters = waiting
+}
+
+// countingClientTest counts the queries it answers.
+type countingClientTest struct {
+	queries atomic.Int32
+}
+
+func (c *countingClientTest) query(q *dns.Msg, _ string) (*dns.Msg, time.Duration, error) {
+	c.queries.Add(1)
+	m := new(dns.Msg)
+	m.SetReply(q)
+	return m, time.Millisecond, nil
+}
+
+func (c *countingClientTest) close() {}
+
+// startTestWorker starts a worker that queries the given client and
+// returns it along with the clock it runs on.
+func startTestWorker(t *testing.T, d *dnsStream, startOffset time.Duration, client *countingClientTest) (*watchdogWorker, *fakeClock) {
+	t.Helper()
+	clk := newFakeClock()
+	ww := newWatchdogWorker(d, startOffset, clk)
+	ww.newClient = func(*dnsRequest) resolverClient { return client }
+	done := make(chan struct{})
+	go func() {
+		ww.watch()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		ww.exit <- true
+		<-done
+	})
+	return ww, clk
+}
+
+func newTestWorkerStream(interval int) *dnsStream {
+	resolver := "127.0.0.1"
+	return newDNSStream(&dnsRequest{domain: "thebeat.co", queryType: "A", resolver: &resolver}, interval)
+}
+
+func TestWatchdogWorkerRunsImmediately(t *testing.T) {
+	t.Parallel()
+	client := &countingClientTest{}
+	_, clk := startTestWorker(t, newTestWorkerStream(60), 0, client)
+
+	require.Eventually(t, func() bool { return client.queries.Load() == 1 }, time.Second, time.Millisecond)
+
+	clk.advance(59 * time.Second)
+	assert.Never(t, func() bool { return client.queries.Load() > 1 }, 50*time.Millisecond, time.Millisecond)
+
+	clk.advance(time.Second)
+	require.Eventually(t, func() bool { return client.queries.Load() == 2 }, time.Second, time.Millisecond)
+
+	// Runs missed while the clock jumped are skipped
+	clk.advance(150 * time.Second)
+	require.Eventually(t, func() bool { return client.queries.Load() == 3 }, time.Second, time.Millisecond)
+	assert.Never(t, func() bool { return client.queries.Load() > 3 }, 50*time.Millisecond, time.Millisecond)
+	clk.advance(30 * time.Second)
+	require.Eventually(t, func() bool { return client.queries.Load() == 4 }, time.Second, time.Millisecond)
+}
+
+func TestWatchdogWorkerDelay(t *testing.T) {
+	t.Parallel()
+	d := newTestWorkerStream(60)
+	d.jitter = 10 * time.Second
+	client := &countingClientTest{}
+	ww, clk := startTestWorker(t, d, 30*time.Second, client)
+
+	assert.GreaterOrEqual(t, ww.delay, 30*time.Second)
+	assert.Less(t, ww.delay, 40*time.Second)
+	assert.Never(t, func() bool { return client.queries.Load() > 0 }, 50*time.Millisecond, time.Millisecond)
+
+	clk.advance(ww.delay)
+	require.Eventually(t, func() bool { return client.queries.Load() == 1 }, time.Second, time.Millisecond)
+}
+
+func TestWatchdogWorkerSplay(t *testing.T) {
+	t.Parallel()
+	d := newTestWorkerStream(60)
+	d.jitter = time.Minute
+
+	// Workers with the same interval don't all start at the same time
+	delays := map[time.Duration]bool{}
+	for range 10 {
+		ww := newWatchdogWorker(d, 0, newFakeClock())
+		assert.Less(t, ww.delay, time.Minute)
+		delays[ww.delay] = true
+	}
+	assert.Greater(t, len(delays), 1)
+
+	d.jitter = 0
+	assert.Zero(t, newWatchdogWorker(d, 0, newFakeClock()).delay)
+}